@@ -1,19 +1,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/itsjoetree/forest-life/db"
+	"github.com/itsjoetree/forest-life/pubsub"
 	"github.com/itsjoetree/forest-life/router"
 	"github.com/itsjoetree/forest-life/services"
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Port string
+	Port           string
+	PubSub         string
+	Reactions      []string
+	EditWindow     time.Duration
+	TrashRetention time.Duration
+	MaxPinnedPosts int
+	PollVoteChange bool
 }
 
 type Application struct {
@@ -45,7 +56,49 @@ func main() {
 	}
 
 	cfg := Config{
-		Port: os.Getenv("PORT"),
+		Port:   os.Getenv("PORT"),
+		PubSub: os.Getenv("PUBSUB"),
+	}
+
+	// Comma separated list, e.g. REACTIONS=🌲,🍄,🌿
+	if reactions := os.Getenv("REACTIONS"); reactions != "" {
+		for _, reaction := range strings.Split(reactions, ",") {
+			if reaction = strings.TrimSpace(reaction); reaction != "" {
+				cfg.Reactions = append(cfg.Reactions, reaction)
+			}
+		}
+	}
+
+	// How long posts stay editable after publishing, e.g. POST_EDIT_WINDOW=1h.
+	// Unset allows edits at any time.
+	if window := os.Getenv("POST_EDIT_WINDOW"); window != "" {
+		cfg.EditWindow, err = time.ParseDuration(window)
+		if err != nil {
+			log.Fatal("Invalid POST_EDIT_WINDOW", err)
+		}
+	}
+
+	// e.g. TRASH_RETENTION=720h. Defaults to 30 days.
+	if retention := os.Getenv("TRASH_RETENTION"); retention != "" {
+		cfg.TrashRetention, err = time.ParseDuration(retention)
+		if err != nil {
+			log.Fatal("Invalid TRASH_RETENTION", err)
+		}
+	}
+
+	if maxPinned := os.Getenv("MAX_PINNED_POSTS"); maxPinned != "" {
+		cfg.MaxPinnedPosts, err = strconv.Atoi(maxPinned)
+		if err != nil {
+			log.Fatal("Invalid MAX_PINNED_POSTS", err)
+		}
+	}
+
+	// POLL_VOTE_CHANGES=true lets voters change their vote until the poll ends
+	if changes := os.Getenv("POLL_VOTE_CHANGES"); changes != "" {
+		cfg.PollVoteChange, err = strconv.ParseBool(changes)
+		if err != nil {
+			log.Fatal("Invalid POLL_VOTE_CHANGES", err)
+		}
 	}
 
 	dsn := os.Getenv("DSN")
@@ -61,6 +114,32 @@ func main() {
 		Models: services.New(dbConn.DB),
 	}
 
+	services.SetReactions(cfg.Reactions)
+	services.SetEditWindow(cfg.EditWindow)
+	services.SetTrashRetention(cfg.TrashRetention)
+	services.SetMaxPinnedPosts(cfg.MaxPinnedPosts)
+	services.SetPollVoteChanges(cfg.PollVoteChange)
+
+	// Replicas behind a load balancer need PUBSUB=postgres so real-time
+	// events reach clients connected to any of them
+	if cfg.PubSub == "postgres" {
+		ps, err := pubsub.NewPostgres(dsn, dbConn.DB)
+		if err != nil {
+			log.Fatal("Error connecting pubsub listener", err)
+		}
+
+		defer ps.Close()
+
+		services.UsePubSub(ps)
+	}
+
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	services.StartWorkers(workers, func(err error) {
+		log.Println("Background job failed:", err)
+	})
+
 	err = app.Serve()
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var accountState services.AccountState
+
+// PUT/moderation/users/{id}/state
+func SetAccountState(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var body services.AccountState
+	err = json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	state, status, err := accountState.SetAccountState(chi.URLParam(r, "id"), body, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, state)
+}
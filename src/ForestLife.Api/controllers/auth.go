@@ -22,6 +22,13 @@ func SignIn(w http.ResponseWriter, r *http.Request) {
 
 	cookie, status, err := auth.SignIn(creds)
 
+	// Suspended users are told why and until when
+	var suspended *services.SuspendedError
+	if errors.As(err, &suspended) {
+		helpers.WriteJSON(w, status, services.JsonResponse{Error: true, Message: err.Error(), Data: suspended})
+		return
+	}
+
 	if err != nil {
 		helpers.ErrorJSON(w, err, status)
 		return
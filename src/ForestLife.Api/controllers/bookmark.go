@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var bookmark services.Bookmark
+
+// POST/posts/{id}/bookmark
+func AddBookmark(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		helpers.ErrorJSON(w, errors.New("idRequired"), http.StatusBadRequest)
+		return
+	}
+
+	// The body is optional; without one the bookmark is left unsorted
+	var body services.Bookmark
+	err = json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil && err != io.EOF {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	status, err := bookmark.AddBookmark(id, body, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, nil)
+}
+
+// POST/posts/{id}/unbookmark
+func RemoveBookmark(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		helpers.ErrorJSON(w, errors.New("idRequired"), http.StatusBadRequest)
+		return
+	}
+
+	status, err := bookmark.RemoveBookmark(id, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, nil)
+}
+
+// GET/bookmarks?limit={limit}&cursor={cursor}
+func GetBookmarks(w http.ResponseWriter, r *http.Request) {
+	getBookmarks(w, r, "")
+}
+
+// GET/bookmarks/collections/{id}/posts?limit={limit}&cursor={cursor}
+func GetCollectionBookmarks(w http.ResponseWriter, r *http.Request) {
+	getBookmarks(w, r, chi.URLParam(r, "id"))
+}
+
+func getBookmarks(w http.ResponseWriter, r *http.Request, collectionId string) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	posts, next, status, err := bookmark.GetBookmarks(collectionId, page, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": posts, "next_cursor": next})
+}
+
+// GET/bookmarks/collections
+func GetBookmarkCollections(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	collections, status, err := bookmark.GetCollections(sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"collections": collections})
+}
+
+// POST/bookmarks/collections
+func CreateBookmarkCollection(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	collection, status, err := bookmark.CreateCollection(body.Name, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"collection": collection})
+}
+
+// DELETE/bookmarks/collections/{id}
+func DeleteBookmarkCollection(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	status, err := bookmark.DeleteCollection(chi.URLParam(r, "id"), sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, nil)
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var keywordFilter services.KeywordFilter
+
+// GET/filters
+func GetFilters(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	filters, status, err := keywordFilter.GetFilters(sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"filters": filters})
+}
+
+// POST/filters
+func CreateFilter(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var body services.KeywordFilter
+	err = json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	filter, status, err := keywordFilter.CreateFilter(body, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"filter": filter})
+}
+
+// PUT/filters/{id}
+func UpdateFilter(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var body services.KeywordFilter
+	err = json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	filter, status, err := keywordFilter.UpdateFilter(chi.URLParam(r, "id"), body, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"filter": filter})
+}
+
+// DELETE/filters/{id}
+func DeleteFilter(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	status, err := keywordFilter.DeleteFilter(chi.URLParam(r, "id"), sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, nil)
+}
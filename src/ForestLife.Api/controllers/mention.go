@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var mention services.Mention
+
+// GET/mentions?limit={limit}&cursor={cursor}
+func GetMentions(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	posts, next, status, err := mention.GetMentions(page, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": posts, "next_cursor": next})
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var conversation services.Conversation
+
+// GET/conversations?limit={limit}&cursor={cursor}
+func GetConversations(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	conversations, next, status, err := conversation.GetConversations(page, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"conversations": conversations, "next_cursor": next})
+}
+
+// POST/conversations
+func StartConversation(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var body services.NewConversation
+	err = json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	started, status, err := conversation.StartConversation(body, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"conversation": started})
+}
+
+// GET/conversations/{id}/messages?limit={limit}&cursor={cursor}
+func GetMessages(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	messages, next, status, err := conversation.GetMessages(id, page, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"messages": messages, "next_cursor": next})
+}
+
+// POST/conversations/{id}/messages
+func SendMessage(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var body services.Message
+	err = json.NewDecoder(r.Body).Decode(&body)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	sent, status, err := conversation.SendMessage(id, body, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"message": sent})
+}
+
+// POST/conversations/{id}/read
+func MarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	status, err := conversation.MarkRead(id, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, nil)
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var notification services.Notification
+
+// GET/notifications?types={type,type}&unread={true|false}&limit={limit}&cursor={cursor}
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var types []string
+	if param := r.URL.Query().Get("types"); param != "" {
+		types = strings.Split(param, ",")
+	}
+
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	notifications, next, status, err := notification.GetNotifications(page, types, unreadOnly, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"notifications": notifications, "next_cursor": next})
+}
+
+// GET/notifications/grouped?types={type,type}&limit={limit}&cursor={cursor}
+func GetNotificationGroups(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var types []string
+	if param := r.URL.Query().Get("types"); param != "" {
+		types = strings.Split(param, ",")
+	}
+
+	groups, next, status, err := notification.GetNotificationGroups(page, types, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"groups": groups, "next_cursor": next})
+}
+
+// GET/notifications/unread_count
+func GetUnreadNotificationCount(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	count, status, err := notification.GetUnreadCount(sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"unread_count": count})
+}
+
+// POST/notifications/{id}/read
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	status, err := notification.MarkRead(id, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, nil)
+}
+
+// POST/notifications/read_all
+func MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	status, err := notification.MarkAllRead(sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, nil)
+}
@@ -3,7 +3,9 @@ package controllers
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi"
 	"github.com/itsjoetree/forest-life/helpers"
@@ -23,9 +25,12 @@ func LikePost(w http.ResponseWriter, r *http.Request) {
 
 	id := chi.URLParam(r, "id")
 
-	err = post.LikePost(id, sessionId)
+	status, err := post.LikePost(id, sessionId)
+
 	if err != nil {
-		helpers.ErrorJSON(w, errors.New("serverError"))
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
 	}
 
 	helpers.WriteJSON(w, http.StatusOK, nil)
@@ -60,7 +65,10 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := post.GetPosts(authorId)
+	// Signed-in callers also get their own reactions back
+	sessionId, _ := auth.GetSessionId(r)
+
+	posts, err := post.GetPosts(authorId, sessionId)
 	if err != nil {
 		helpers.MessageLogs.ErrorLog.Println(err)
 		helpers.ErrorJSON(w, errors.New("Unable to get posts"), http.StatusInternalServerError)
@@ -70,10 +78,39 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": posts})
 }
 
+// GET/timeline/home?limit={limit}&cursor={cursor}
+func GetHomeTimeline(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	posts, next, status, err := post.GetHomeTimeline(page, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": posts, "next_cursor": next})
+}
+
 // GET.posts/{id}
 func GetPostById(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	post, err := post.GetPostById(id)
+	sessionId, _ := auth.GetSessionId(r)
+	post, err := post.GetPostById(id, sessionId)
 
 	if err != nil {
 		helpers.MessageLogs.ErrorLog.Println(err)
@@ -103,11 +140,12 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	postCreated, err := post.CreatePost(newPost, sessionId)
+	postCreated, status, err := post.CreatePost(newPost, sessionId)
 
 	if err != nil {
 		helpers.MessageLogs.ErrorLog.Println(err)
-		helpers.ErrorJSON(w, errors.New("Unable to create post"), http.StatusInternalServerError)
+		setRetryAfter(w, err)
+		helpers.ErrorJSON(w, err, status)
 		return
 	}
 
@@ -125,24 +163,275 @@ func UpdatePost(w http.ResponseWriter, r *http.Request) {
 
 	id := chi.URLParam(r, "id")
 
-	err = json.NewDecoder(r.Body).Decode(&post)
+	// Decode into a fresh value so fields from an earlier request never leak
+	// into this one
+	var body services.Post
+	err = json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		helpers.MessageLogs.ErrorLog.Println(err)
 		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
 		return
 	}
 
-	postUpdated, err := post.UpdatePost(id, post, sessionId)
+	postUpdated, status, err := post.UpdatePost(id, body, sessionId)
 
 	if err != nil {
 		helpers.MessageLogs.ErrorLog.Println(err)
-		helpers.ErrorJSON(w, errors.New("Unable to update post"), http.StatusInternalServerError)
+		setRetryAfter(w, err)
+		helpers.ErrorJSON(w, err, status)
 		return
 	}
 
 	helpers.WriteJSON(w, http.StatusOK, postUpdated)
 }
 
+// setRetryAfter tells rate limited clients how long to wait, in whole seconds
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var limited *services.RateLimitError
+
+	if errors.As(err, &limited) {
+		seconds := int(math.Ceil(limited.RetryAfter.Seconds()))
+
+		if seconds < 1 {
+			seconds = 1
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
+
+// GET/posts/{id}/history
+func GetPostHistory(w http.ResponseWriter, r *http.Request) {
+	sessionId, _ := auth.GetSessionId(r)
+
+	history, status, err := post.GetPostHistory(chi.URLParam(r, "id"), sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"history": history})
+}
+
+// GET/posts/drafts
+func GetDrafts(w http.ResponseWriter, r *http.Request) {
+	getUnpublishedPosts(w, r, services.PostDraft)
+}
+
+// GET/posts/scheduled
+func GetScheduledPosts(w http.ResponseWriter, r *http.Request) {
+	getUnpublishedPosts(w, r, services.PostScheduled)
+}
+
+// GET/posts/held
+func GetHeldPosts(w http.ResponseWriter, r *http.Request) {
+	getUnpublishedPosts(w, r, services.PostHeld)
+}
+
+func getUnpublishedPosts(w http.ResponseWriter, r *http.Request, status string) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	posts, code, err := post.GetUnpublishedPosts(status, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, code)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": posts})
+}
+
+// POST/posts/{id}/unschedule
+func UnschedulePost(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	draft, status, err := post.UnschedulePost(chi.URLParam(r, "id"), sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, draft)
+}
+
+// GET/posts/trash
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	posts, status, err := post.GetTrash(sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": posts})
+}
+
+// POST/posts/{id}/restore
+func RestorePost(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	restored, status, err := post.RestorePost(chi.URLParam(r, "id"), sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, restored)
+}
+
+// POST/posts/{id}/sensitive
+func MarkPostSensitive(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	marked, status, err := post.MarkSensitive(chi.URLParam(r, "id"), sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, marked)
+}
+
+// GET/moderation/held_posts?limit={limit}&cursor={cursor}
+func GetHeldPostQueue(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	held, next, status, err := post.GetHeldPosts(page, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": held, "next_cursor": next})
+}
+
+// POST/moderation/held_posts/{id}/approve
+func ApprovePost(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	note, err := readNote(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	approved, status, err := post.ApprovePost(chi.URLParam(r, "id"), note, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, approved)
+}
+
+// POST/moderation/held_posts/{id}/reject
+func RejectPost(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	note, err := readNote(r)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	status, err := post.RejectPost(chi.URLParam(r, "id"), note, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, nil)
+}
+
+// readNote reads the optional moderator note sent with a decision
+func readNote(r *http.Request) (string, error) {
+	var body struct {
+		Note string `json:"note"`
+	}
+
+	if r.ContentLength == 0 {
+		return "", nil
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+
+	return body.Note, err
+}
+
 // DELETE/posts/{id}
 func DeletePost(w http.ResponseWriter, r *http.Request) {
 	sessionId, err := auth.GetSessionId(r)
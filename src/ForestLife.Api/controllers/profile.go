@@ -1,8 +1,10 @@
 package controllers
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/itsjoetree/forest-life/helpers"
 	"github.com/itsjoetree/forest-life/services"
@@ -28,3 +30,51 @@ func GetProfile(w http.ResponseWriter, r *http.Request) {
 
 	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"profile": profile})
 }
+
+// PATCH/profile/settings
+func UpdateProfileSettings(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	var settings services.ProfileSettings
+	err = json.NewDecoder(r.Body).Decode(&settings)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, errors.New("Invalid JSON"))
+		return
+	}
+
+	updated, status, err := profile.UpdateSettings(settings, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"profile": updated})
+}
+
+// GET/profiles/search?q={query}&limit={limit}
+func SearchProfiles(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	// Signed-in callers get accounts they follow ranked higher
+	sessionId, _ := auth.GetSessionId(r)
+
+	profiles, status, err := profile.SearchProfiles(q, limit, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"profiles": profiles})
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var search services.Search
+
+// GET/search/posts?q={query}&since={date}&until={date}&sort={relevance|recent}&limit={limit}&cursor={cursor}
+func SearchPosts(w http.ResponseWriter, r *http.Request) {
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	params := services.SearchParams{
+		Query: r.URL.Query().Get("q"),
+		Since: r.URL.Query().Get("since"),
+		Until: r.URL.Query().Get("until"),
+		Sort:  r.URL.Query().Get("sort"),
+	}
+
+	// Signed-in callers don't see posts from accounts they have blocked
+	sessionId, _ := auth.GetSessionId(r)
+
+	posts, next, status, err := search.SearchPosts(params, page, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": posts, "next_cursor": next})
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var stream services.Stream
+
+// Comment lines sent while idle keep proxies from closing the connection
+const streamHeartbeat = 15 * time.Second
+
+// GET/streaming
+func Streaming(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := auth.GetSessionId(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		helpers.ErrorJSON(w, errors.New("streamingUnsupported"), http.StatusInternalServerError)
+		return
+	}
+
+	// Browsers send Last-Event-ID when reconnecting; other clients may only be
+	// able to pass it in the query string
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+
+	sub, status, err := stream.Subscribe(lastEventId, sessionId)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range sub.Backlog {
+		event, ok := sub.Filter(event)
+
+		if !ok {
+			continue
+		}
+
+		if writeEvent(w, event) != nil {
+			return
+		}
+	}
+
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Lagged:
+			// The client fell too far behind; it reconnects and resumes
+			// from the last event it received
+			return
+		case event := <-sub.Events:
+			event, ok := sub.Filter(event)
+
+			if ok && writeEvent(w, event) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if !sub.Authorized(sessionId) {
+				return
+			}
+
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event services.StreamEvent) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/itsjoetree/forest-life/helpers"
+	"github.com/itsjoetree/forest-life/services"
+)
+
+var tag services.Tag
+
+// GET/tags/{tag}
+func GetTag(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tag")
+
+	stats, status, err := tag.GetTag(name)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"tag": stats})
+}
+
+// GET/tags/{tag}/posts?limit={limit}&cursor={cursor}
+func GetTagPosts(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tag")
+
+	page, err := helpers.ReadPage(r)
+
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	sessionId, _ := auth.GetSessionId(r)
+
+	posts, next, status, err := tag.GetTagPosts(name, page, sessionId)
+
+	if err != nil {
+		helpers.MessageLogs.ErrorLog.Println(err)
+		helpers.ErrorJSON(w, err, status)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.Envelope{"posts": posts, "next_cursor": next})
+}
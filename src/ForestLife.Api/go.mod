@@ -25,5 +25,5 @@ require (
 	github.com/jackc/pgtype v1.14.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/crypto v0.6.0
-	golang.org/x/text v0.7.0 // indirect
+	golang.org/x/text v0.7.0
 )
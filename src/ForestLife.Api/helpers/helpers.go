@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/itsjoetree/forest-life/services"
 )
@@ -76,3 +77,9 @@ func ErrorJSON(w http.ResponseWriter, err error, status ...int) {
 	payload.Message = err.Error()
 	WriteJSON(w, statusCode, payload)
 }
+
+// ReadPage reads the limit and cursor query parameters used by paginated endpoints
+func ReadPage(r *http.Request) (services.Page, error) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	return services.NewPage(limit, r.URL.Query().Get("cursor"))
+}
@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS post_likes DROP CONSTRAINT IF EXISTS UQ_post_likes;
+
+DROP TABLE IF EXISTS post_reactions;
@@ -0,0 +1,16 @@
+CREATE TABLE post_reactions (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    post_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    reaction VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_post_reactions_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+    CONSTRAINT FK_post_reactions_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT UQ_post_reactions UNIQUE (post_id, user_id, reaction)
+);
+
+CREATE INDEX idx_post_reactions_post_id ON post_reactions (post_id);
+
+-- A post can be liked by many users, but only once per user
+ALTER TABLE post_likes DROP CONSTRAINT IF EXISTS post_likes_post_id_key;
+ALTER TABLE post_likes ADD CONSTRAINT UQ_post_likes UNIQUE (post_id, user_id);
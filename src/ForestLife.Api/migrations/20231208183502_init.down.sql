@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_profiles_username_lower;
+DROP TABLE IF EXISTS post_mentions;
@@ -0,0 +1,11 @@
+CREATE TABLE post_mentions (
+    post_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (post_id, user_id),
+    CONSTRAINT FK_post_mentions_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+    CONSTRAINT FK_post_mentions_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_post_mentions_user_id ON post_mentions (user_id);
+CREATE INDEX idx_profiles_username_lower ON profiles (lower(username));
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS notifications;
+
+ALTER TABLE IF EXISTS follow_relationships DROP CONSTRAINT IF EXISTS UQ_follow_relationships;
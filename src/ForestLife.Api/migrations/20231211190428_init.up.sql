@@ -0,0 +1,19 @@
+-- A user can be followed by many users, but only once by each
+ALTER TABLE follow_relationships DROP CONSTRAINT IF EXISTS follow_relationships_followee_id_key;
+ALTER TABLE follow_relationships ADD CONSTRAINT UQ_follow_relationships UNIQUE (followee_id, follower_id);
+
+CREATE TABLE notifications (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    actor_id uuid NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    post_id uuid,
+    read_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_notifications_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_notifications_actor_id FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_notifications_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_notifications_user_id ON notifications (user_id, created_at DESC, id DESC);
+CREATE INDEX idx_notifications_unread ON notifications (user_id) WHERE read_at IS NULL;
@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS conversation_members;
+DROP TABLE IF EXISTS conversations;
+
+ALTER TABLE IF EXISTS profiles DROP COLUMN IF EXISTS dm_policy;
+DROP TYPE IF EXISTS dm_policy;
+
+DROP TABLE IF EXISTS user_blocks;
+
+COMMIT;
@@ -0,0 +1,51 @@
+BEGIN;
+
+CREATE TABLE user_blocks (
+    blocker_id uuid NOT NULL,
+    blocked_id uuid NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CONSTRAINT FK_user_blocks_blocker_id FOREIGN KEY (blocker_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_user_blocks_blocked_id FOREIGN KEY (blocked_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_blocks_blocked_id ON user_blocks (blocked_id);
+
+CREATE TYPE dm_policy AS ENUM ('everyone', 'following');
+
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS dm_policy dm_policy NOT NULL DEFAULT 'everyone';
+
+CREATE TABLE conversations (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    created_by uuid NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_conversations_created_by FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE conversation_members (
+    conversation_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    last_read_at TIMESTAMP WITH TIME ZONE,
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (conversation_id, user_id),
+    CONSTRAINT FK_conversation_members_conversation_id FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+    CONSTRAINT FK_conversation_members_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_conversation_members_user_id ON conversation_members (user_id);
+
+CREATE TABLE messages (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    conversation_id uuid NOT NULL,
+    sender_id uuid NOT NULL,
+    text VARCHAR(2000) NOT NULL DEFAULT '',
+    media VARCHAR(500) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_messages_conversation_id FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+    CONSTRAINT FK_messages_sender_id FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_messages_conversation_id ON messages (conversation_id, created_at DESC, id DESC);
+
+COMMIT;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_posts_search_vector;
+
+ALTER TABLE IF EXISTS posts DROP COLUMN IF EXISTS search_vector;
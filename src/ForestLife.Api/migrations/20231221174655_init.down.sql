@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_follow_relationships_followee_id;
+DROP INDEX IF EXISTS idx_profiles_nickname_trgm;
+DROP INDEX IF EXISTS idx_profiles_username_trgm;
@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_profiles_username_trgm ON profiles USING GIN (lower(username) gin_trgm_ops);
+CREATE INDEX idx_profiles_nickname_trgm ON profiles USING GIN (lower(COALESCE(nickname, '')) gin_trgm_ops);
+CREATE INDEX idx_follow_relationships_followee_id ON follow_relationships (followee_id);
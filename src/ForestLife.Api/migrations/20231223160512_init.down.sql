@@ -0,0 +1,16 @@
+BEGIN;
+
+DROP TABLE IF EXISTS worker_runs;
+DROP TABLE IF EXISTS trending_tag_authors;
+DROP TABLE IF EXISTS trending_posts;
+DROP TABLE IF EXISTS trend_excluded_tags;
+DROP TABLE IF EXISTS user_mutes;
+
+DROP INDEX IF EXISTS idx_post_reactions_created_at;
+DROP INDEX IF EXISTS idx_post_likes_created_at;
+ALTER TABLE post_likes DROP COLUMN IF EXISTS created_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS state;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+
+COMMIT;
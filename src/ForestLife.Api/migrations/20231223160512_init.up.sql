@@ -0,0 +1,51 @@
+BEGIN;
+
+ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'moderator', 'admin'));
+ALTER TABLE users ADD COLUMN state VARCHAR(16) NOT NULL DEFAULT 'active'
+    CHECK (state IN ('active', 'suspended'));
+
+ALTER TABLE post_likes ADD COLUMN created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+CREATE INDEX idx_post_likes_created_at ON post_likes (created_at);
+CREATE INDEX idx_post_reactions_created_at ON post_reactions (created_at);
+
+CREATE TABLE user_mutes (
+    muter_id uuid NOT NULL,
+    muted_id uuid NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (muter_id, muted_id),
+    CONSTRAINT FK_user_mutes_muter_id FOREIGN KEY (muter_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_user_mutes_muted_id FOREIGN KEY (muted_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE trend_excluded_tags (
+    name VARCHAR(255) PRIMARY KEY NOT NULL,
+    excluded_by uuid,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_trend_excluded_tags_excluded_by FOREIGN KEY (excluded_by) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE TABLE trending_posts (
+    post_id uuid PRIMARY KEY NOT NULL,
+    author_id uuid NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    CONSTRAINT FK_trending_posts_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+-- Tag scores are kept per author so trends can leave out the contributions
+-- of accounts a viewer has muted
+CREATE TABLE trending_tag_authors (
+    tag_id uuid NOT NULL,
+    author_id uuid NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (tag_id, author_id),
+    CONSTRAINT FK_trending_tag_authors_tag_id FOREIGN KEY (tag_id) REFERENCES tags (id) ON DELETE CASCADE
+);
+
+-- Last run of each periodic background job, shared by all replicas
+CREATE TABLE worker_runs (
+    name VARCHAR(64) PRIMARY KEY NOT NULL,
+    ran_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+COMMIT;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_follow_relationships_follower_id;
+DROP TABLE IF EXISTS suggestion_dismissals;
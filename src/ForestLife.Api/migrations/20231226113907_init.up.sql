@@ -0,0 +1,10 @@
+CREATE TABLE suggestion_dismissals (
+    user_id uuid NOT NULL,
+    dismissed_id uuid NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, dismissed_id),
+    CONSTRAINT FK_suggestion_dismissals_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_suggestion_dismissals_dismissed_id FOREIGN KEY (dismissed_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_follow_relationships_follower_id ON follow_relationships (follower_id);
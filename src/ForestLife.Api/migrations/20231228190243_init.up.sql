@@ -0,0 +1,25 @@
+CREATE TABLE bookmark_collections (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT UQ_bookmark_collections UNIQUE (user_id, name),
+    CONSTRAINT FK_bookmark_collections_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- A bookmark sits in at most one collection; deleting the collection leaves
+-- its bookmarks unsorted rather than removing them
+CREATE TABLE bookmarks (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    post_id uuid NOT NULL,
+    collection_id uuid,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT UQ_bookmarks UNIQUE (user_id, post_id),
+    CONSTRAINT FK_bookmarks_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_bookmarks_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+    CONSTRAINT FK_bookmarks_collection_id FOREIGN KEY (collection_id) REFERENCES bookmark_collections (id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_bookmarks_user_id_created_at ON bookmarks (user_id, created_at DESC, id DESC);
+CREATE INDEX idx_bookmarks_collection_id ON bookmarks (collection_id);
@@ -0,0 +1,7 @@
+ALTER TABLE posts ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'published'
+    CHECK (status IN ('published', 'draft', 'scheduled'));
+ALTER TABLE posts ADD COLUMN publish_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE posts ADD CONSTRAINT CK_posts_publish_at
+    CHECK (status <> 'scheduled' OR publish_at IS NOT NULL);
+
+CREATE INDEX idx_posts_scheduled ON posts (publish_at) WHERE status = 'scheduled';
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP TABLE IF EXISTS post_revisions;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS edit_count;
+ALTER TABLE posts DROP COLUMN IF EXISTS edited_at;
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+ALTER TABLE posts ADD COLUMN edited_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE posts ADD COLUMN edit_count INTEGER NOT NULL DEFAULT 0;
+
+-- Earlier versions of published posts. created_at is when the version went
+-- live, replaced_at when an edit replaced it.
+CREATE TABLE post_revisions (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    post_id uuid NOT NULL,
+    text VARCHAR(500) NOT NULL,
+    image VARCHAR(500) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    replaced_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_post_revisions_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_post_revisions_post_id ON post_revisions (post_id, created_at DESC);
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE post_likes DROP CONSTRAINT IF EXISTS FK_post_likes_post_id;
+ALTER TABLE post_likes ADD CONSTRAINT FK_post_likes_post_id
+    FOREIGN KEY (post_id) REFERENCES posts (id);
+
+DROP INDEX IF EXISTS idx_posts_deleted_at;
+ALTER TABLE posts DROP COLUMN IF EXISTS deleted_at;
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE posts ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX idx_posts_deleted_at ON posts (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Purging a post must take its likes with it
+ALTER TABLE post_likes DROP CONSTRAINT IF EXISTS FK_post_likes_post_id;
+ALTER TABLE post_likes ADD CONSTRAINT FK_post_likes_post_id
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE;
+
+COMMIT;
@@ -0,0 +1,11 @@
+CREATE TABLE pinned_posts (
+    user_id uuid NOT NULL,
+    post_id uuid NOT NULL,
+    position INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, post_id),
+    CONSTRAINT FK_pinned_posts_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_pinned_posts_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_pinned_posts_post_id ON pinned_posts (post_id);
@@ -0,0 +1,7 @@
+BEGIN;
+
+DROP TABLE IF EXISTS poll_votes;
+DROP TABLE IF EXISTS poll_options;
+DROP TABLE IF EXISTS polls;
+
+COMMIT;
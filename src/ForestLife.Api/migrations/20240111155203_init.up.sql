@@ -0,0 +1,38 @@
+BEGIN;
+
+CREATE TABLE polls (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    post_id uuid NOT NULL UNIQUE,
+    multiple BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- Set once the author has been told the poll ended
+    ended_notified_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_polls_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_polls_pending_end ON polls (expires_at) WHERE ended_notified_at IS NULL;
+
+CREATE TABLE poll_options (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    poll_id uuid NOT NULL,
+    position INTEGER NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    CONSTRAINT UQ_poll_options UNIQUE (poll_id, position),
+    CONSTRAINT FK_poll_options_poll_id FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE
+);
+
+CREATE TABLE poll_votes (
+    poll_id uuid NOT NULL,
+    option_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (option_id, user_id),
+    CONSTRAINT FK_poll_votes_poll_id FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE,
+    CONSTRAINT FK_poll_votes_option_id FOREIGN KEY (option_id) REFERENCES poll_options (id) ON DELETE CASCADE,
+    CONSTRAINT FK_poll_votes_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_poll_votes_poll_id_user_id ON poll_votes (poll_id, user_id);
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE IF EXISTS profiles DROP COLUMN IF EXISTS sensitive_media;
+DROP TYPE IF EXISTS sensitive_media;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS sensitive_forced;
+ALTER TABLE posts DROP COLUMN IF EXISTS sensitive;
+ALTER TABLE posts DROP COLUMN IF EXISTS spoiler_text;
+
+COMMIT;
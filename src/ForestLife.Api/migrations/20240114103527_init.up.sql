@@ -0,0 +1,12 @@
+BEGIN;
+
+ALTER TABLE posts ADD COLUMN spoiler_text VARCHAR(200) NOT NULL DEFAULT '';
+ALTER TABLE posts ADD COLUMN sensitive BOOLEAN NOT NULL DEFAULT FALSE;
+-- Set by moderators; the author can no longer unmark the post
+ALTER TABLE posts ADD COLUMN sensitive_forced BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TYPE sensitive_media AS ENUM ('default', 'expand', 'hide');
+
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS sensitive_media sensitive_media NOT NULL DEFAULT 'default';
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP TABLE IF EXISTS post_links;
+DROP TABLE IF EXISTS link_previews;
+
+COMMIT;
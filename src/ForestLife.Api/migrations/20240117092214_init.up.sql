@@ -0,0 +1,27 @@
+BEGIN;
+
+-- One row per URL, shared by every post linking to it. A row without
+-- fetched_at has been claimed by a replica that is fetching it.
+CREATE TABLE link_previews (
+    url VARCHAR(2048) PRIMARY KEY NOT NULL,
+    title VARCHAR(200) NOT NULL DEFAULT '',
+    description VARCHAR(500) NOT NULL DEFAULT '',
+    image VARCHAR(2048) NOT NULL DEFAULT '',
+    site_name VARCHAR(100) NOT NULL DEFAULT '',
+    failed BOOLEAN NOT NULL DEFAULT FALSE,
+    claimed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    fetched_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_link_previews_pending ON link_previews (claimed_at) WHERE fetched_at IS NULL;
+
+-- The link a post's preview card is made from
+CREATE TABLE post_links (
+    post_id uuid PRIMARY KEY NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    CONSTRAINT FK_post_links_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_post_links_url ON post_links (url);
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS FK_posts_deleted_by;
+ALTER TABLE posts DROP COLUMN IF EXISTS deleted_by;
+
+DROP TABLE IF EXISTS moderation_actions;
+DROP TABLE IF EXISTS reports;
+
+COMMIT;
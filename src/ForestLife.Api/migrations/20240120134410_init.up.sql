@@ -0,0 +1,47 @@
+BEGIN;
+
+CREATE TABLE reports (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    reporter_id uuid NOT NULL,
+    -- The reported account, or the author of the reported post
+    account_id uuid NOT NULL,
+    post_id uuid,
+    category VARCHAR(16) NOT NULL
+        CHECK (category IN ('spam', 'harassment', 'hate', 'violence', 'sexual', 'misinformation', 'other')),
+    comment VARCHAR(1000) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'open'
+        CHECK (status IN ('open', 'resolved', 'dismissed')),
+    assigned_to uuid,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_reports_reporter_id FOREIGN KEY (reporter_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_reports_account_id FOREIGN KEY (account_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT FK_reports_post_id FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE SET NULL,
+    CONSTRAINT FK_reports_assigned_to FOREIGN KEY (assigned_to) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_reports_queue ON reports (status, created_at DESC, id DESC);
+CREATE INDEX idx_reports_reporter_id ON reports (reporter_id);
+
+-- Every step a moderator takes on a report, with their note
+CREATE TABLE moderation_actions (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    report_id uuid NOT NULL,
+    moderator_id uuid,
+    action VARCHAR(16) NOT NULL
+        CHECK (action IN ('assign', 'resolve', 'dismiss', 'delete_post', 'warn', 'suspend')),
+    note VARCHAR(1000) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_moderation_actions_report_id FOREIGN KEY (report_id) REFERENCES reports (id) ON DELETE CASCADE,
+    CONSTRAINT FK_moderation_actions_moderator_id FOREIGN KEY (moderator_id) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_moderation_actions_report_id ON moderation_actions (report_id, created_at);
+
+-- Set when a moderator removed the post, which keeps its author from
+-- restoring it
+ALTER TABLE posts ADD COLUMN deleted_by uuid;
+ALTER TABLE posts ADD CONSTRAINT FK_posts_deleted_by
+    FOREIGN KEY (deleted_by) REFERENCES users (id) ON DELETE SET NULL;
+
+COMMIT;
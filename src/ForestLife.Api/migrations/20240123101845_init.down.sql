@@ -0,0 +1,20 @@
+BEGIN;
+
+DELETE FROM moderation_actions WHERE report_id IS NULL OR action IN ('limit', 'restore');
+ALTER TABLE moderation_actions DROP CONSTRAINT IF EXISTS moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('assign', 'resolve', 'dismiss', 'delete_post', 'warn', 'suspend'));
+DROP INDEX IF EXISTS idx_moderation_actions_account_id;
+ALTER TABLE moderation_actions DROP CONSTRAINT IF EXISTS FK_moderation_actions_account_id;
+ALTER TABLE moderation_actions DROP COLUMN IF EXISTS account_id;
+ALTER TABLE moderation_actions ALTER COLUMN report_id SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_users_state_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS state_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS state_reason;
+UPDATE users SET state = 'active' WHERE state = 'limited';
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_state_check;
+ALTER TABLE users ADD CONSTRAINT users_state_check
+    CHECK (state IN ('active', 'suspended'));
+
+COMMIT;
@@ -0,0 +1,25 @@
+BEGIN;
+
+-- Limited accounts are only seen by their followers; both limits and
+-- suspensions can lift by themselves at state_expires_at
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_state_check;
+ALTER TABLE users ADD CONSTRAINT users_state_check
+    CHECK (state IN ('active', 'limited', 'suspended'));
+ALTER TABLE users ADD COLUMN state_reason VARCHAR(1000) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN state_expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_state_expires_at ON users (state_expires_at) WHERE state_expires_at IS NOT NULL;
+
+-- Moderators can change an account's state outside of a report, so actions
+-- are recorded against the account as well
+ALTER TABLE moderation_actions ALTER COLUMN report_id DROP NOT NULL;
+ALTER TABLE moderation_actions ADD COLUMN account_id uuid;
+ALTER TABLE moderation_actions ADD CONSTRAINT FK_moderation_actions_account_id
+    FOREIGN KEY (account_id) REFERENCES users (id) ON DELETE CASCADE;
+ALTER TABLE moderation_actions DROP CONSTRAINT IF EXISTS moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('assign', 'resolve', 'dismiss', 'delete_post', 'warn', 'limit', 'suspend', 'restore'));
+
+CREATE INDEX idx_moderation_actions_account_id ON moderation_actions (account_id, created_at);
+
+COMMIT;
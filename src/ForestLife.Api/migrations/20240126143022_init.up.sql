@@ -0,0 +1,21 @@
+BEGIN;
+
+-- Words and phrases a user doesn't want to see, in the contexts they chose
+CREATE TABLE keyword_filters (
+    id uuid PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL,
+    phrase VARCHAR(100) NOT NULL,
+    whole_word BOOLEAN NOT NULL DEFAULT TRUE,
+    contexts TEXT[] NOT NULL
+        CHECK (cardinality(contexts) > 0 AND contexts <@ ARRAY['home', 'notifications', 'replies', 'search']),
+    action VARCHAR(8) NOT NULL DEFAULT 'warn'
+        CHECK (action IN ('hide', 'warn')),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CONSTRAINT FK_keyword_filters_user_id FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX UQ_keyword_filters ON keyword_filters (user_id, lower(phrase));
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+DELETE FROM moderation_actions WHERE action = 'approve_post';
+ALTER TABLE moderation_actions DROP CONSTRAINT IF EXISTS moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('assign', 'resolve', 'dismiss', 'delete_post', 'warn', 'limit', 'suspend', 'restore'));
+
+DROP INDEX IF EXISTS idx_posts_held;
+DROP INDEX IF EXISTS idx_posts_author_id_created_at;
+DROP INDEX IF EXISTS idx_posts_content_hash;
+ALTER TABLE posts DROP COLUMN IF EXISTS content_hash;
+ALTER TABLE posts DROP COLUMN IF EXISTS held_reason;
+
+-- Held posts never went out, so they go back to being drafts
+UPDATE posts SET status = 'draft' WHERE status = 'held';
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_status_check;
+ALTER TABLE posts ADD CONSTRAINT posts_status_check
+    CHECK (status IN ('published', 'draft', 'scheduled'));
+
+COMMIT;
@@ -0,0 +1,23 @@
+BEGIN;
+
+-- Posts that look like spam are held for a moderator to approve or reject
+-- instead of going out
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_status_check;
+ALTER TABLE posts ADD CONSTRAINT posts_status_check
+    CHECK (status IN ('published', 'draft', 'scheduled', 'held'));
+ALTER TABLE posts ADD COLUMN held_reason VARCHAR(16)
+    CHECK (held_reason IN ('links', 'duplicate'));
+
+-- Hash of a post's normalized text, for spotting the same text posted from
+-- many accounts. Short posts aren't hashed.
+ALTER TABLE posts ADD COLUMN content_hash CHAR(64);
+
+CREATE INDEX idx_posts_content_hash ON posts (content_hash, created_at) WHERE content_hash IS NOT NULL;
+CREATE INDEX idx_posts_author_id_created_at ON posts (author_id, created_at DESC);
+CREATE INDEX idx_posts_held ON posts (created_at DESC, id DESC) WHERE status = 'held' AND deleted_at IS NULL;
+
+ALTER TABLE moderation_actions DROP CONSTRAINT IF EXISTS moderation_actions_action_check;
+ALTER TABLE moderation_actions ADD CONSTRAINT moderation_actions_action_check
+    CHECK (action IN ('assign', 'resolve', 'dismiss', 'delete_post', 'approve_post', 'warn', 'limit', 'suspend', 'restore'));
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+DROP INDEX IF EXISTS UQ_posts_text;
+
+-- Deleted posts whose text was posted again can't be kept
+DELETE FROM posts p
+WHERE p.deleted_at IS NOT NULL AND EXISTS (
+    SELECT 1
+    FROM posts other
+    WHERE other.text = p.text
+        AND other.id <> p.id
+        AND (other.deleted_at IS NULL OR other.deleted_at > p.deleted_at)
+);
+
+ALTER TABLE posts ADD CONSTRAINT posts_text_key UNIQUE (text);
+
+COMMIT;
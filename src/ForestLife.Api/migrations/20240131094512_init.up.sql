@@ -0,0 +1,8 @@
+BEGIN;
+
+-- Deleted posts keep their text until they are purged, so only posts that
+-- haven't been deleted need unique text
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_text_key;
+CREATE UNIQUE INDEX UQ_posts_text ON posts (text) WHERE deleted_at IS NULL;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DELETE FROM notifications WHERE type = 'reply';
+
+DROP INDEX IF EXISTS idx_posts_in_reply_to_id;
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS FK_posts_in_reply_to_id;
+ALTER TABLE posts DROP COLUMN IF EXISTS in_reply_to_id;
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN;
+
+-- A reply keeps pointing at its parent while the parent is in the trash and
+-- is left standing on its own once the parent is purged
+ALTER TABLE posts ADD COLUMN in_reply_to_id uuid;
+ALTER TABLE posts ADD CONSTRAINT FK_posts_in_reply_to_id
+    FOREIGN KEY (in_reply_to_id) REFERENCES posts (id) ON DELETE SET NULL;
+
+CREATE INDEX idx_posts_in_reply_to_id ON posts (in_reply_to_id) WHERE in_reply_to_id IS NOT NULL;
+
+COMMIT;
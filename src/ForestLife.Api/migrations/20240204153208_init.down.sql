@@ -0,0 +1,16 @@
+BEGIN;
+
+-- All but the first post with each text are moved to the trash
+UPDATE posts p
+SET deleted_at = NOW()
+WHERE p.deleted_at IS NULL AND EXISTS (
+    SELECT 1
+    FROM posts other
+    WHERE other.text = p.text
+        AND other.deleted_at IS NULL
+        AND (other.created_at, other.id) < (p.created_at, p.id)
+);
+
+CREATE UNIQUE INDEX UQ_posts_text ON posts (text) WHERE deleted_at IS NULL;
+
+COMMIT;
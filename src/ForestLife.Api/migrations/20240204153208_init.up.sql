@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Posts no longer need unique text. The same text from many accounts is
+-- caught through content_hash and held for review instead.
+DROP INDEX IF EXISTS UQ_posts_text;
+
+COMMIT;
@@ -0,0 +1,26 @@
+package pubsub
+
+import "context"
+
+// Memory delivers messages within a single process. It suits single node
+// setups and tests.
+type Memory struct {
+	handlers handlers
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Publish(ctx context.Context, topic string, payload []byte) error {
+	m.handlers.dispatch(topic, payload)
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string, handler Handler) func() {
+	return m.handlers.subscribe(topic, handler)
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
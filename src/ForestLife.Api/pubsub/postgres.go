@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// All topics share one Postgres channel so the listener never has to issue
+// new LISTEN commands while it is waiting for notifications
+const postgresChannel = "forest_life_events"
+
+// Postgres limits NOTIFY payloads to just under 8000 bytes
+const maxPostgresPayload = 7900
+
+const reconnectDelay = 2 * time.Second
+
+type envelope struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Postgres fans messages out to every replica with LISTEN/NOTIFY. Messages
+// are published over the shared connection pool and received on a dedicated
+// connection, including by the replica that published them.
+type Postgres struct {
+	db       *sql.DB
+	dsn      string
+	handlers handlers
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewPostgres(dsn string, db *sql.DB) (*Postgres, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	conn, err := listen(ctx, dsn)
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	ps := &Postgres{
+		db:     db,
+		dsn:    dsn,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go ps.run(ctx, conn)
+
+	return ps, nil
+}
+
+func listen(ctx context.Context, dsn string) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Exec(ctx, "LISTEN "+postgresChannel)
+
+	if err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (p *Postgres) run(ctx context.Context, conn *pgx.Conn) {
+	defer close(p.done)
+
+	for {
+		err := p.receive(ctx, conn)
+		conn.Close(context.Background())
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		fmt.Println("Lost pubsub listener connection, reconnecting:", err)
+
+		// Messages sent while disconnected are lost; subscribers that need
+		// every message must catch up from the database
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reconnectDelay):
+			}
+
+			conn, err = listen(ctx, p.dsn)
+
+			if err == nil {
+				break
+			}
+		}
+	}
+}
+
+func (p *Postgres) receive(ctx context.Context, conn *pgx.Conn) error {
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		var msg envelope
+
+		if err := json.Unmarshal([]byte(notification.Payload), &msg); err != nil {
+			continue
+		}
+
+		p.handlers.dispatch(msg.Topic, msg.Payload)
+	}
+}
+
+func (p *Postgres) Publish(ctx context.Context, topic string, payload []byte) error {
+	msg, err := json.Marshal(envelope{Topic: topic, Payload: payload})
+
+	if err != nil {
+		return err
+	}
+
+	if len(msg) > maxPostgresPayload {
+		return errors.New("payloadTooLarge")
+	}
+
+	_, err = p.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", postgresChannel, string(msg))
+
+	return err
+}
+
+func (p *Postgres) Subscribe(topic string, handler Handler) func() {
+	return p.handlers.subscribe(topic, handler)
+}
+
+func (p *Postgres) Close() error {
+	p.cancel()
+	<-p.done
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+)
+
+// Handler receives the payload of each message published to a topic
+type Handler func(payload []byte)
+
+// PubSub delivers messages published on one API replica to the subscribers
+// on every replica using the same backend. Payloads are JSON encoded.
+type PubSub interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Subscribe(topic string, handler Handler) (unsubscribe func())
+	Close() error
+}
+
+type subscription struct {
+	handler Handler
+}
+
+// handlers keeps the subscribers of each topic, shared by both backends
+type handlers struct {
+	mu     sync.RWMutex
+	topics map[string]map[*subscription]bool
+}
+
+func (h *handlers) subscribe(topic string, handler Handler) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.topics == nil {
+		h.topics = map[string]map[*subscription]bool{}
+	}
+
+	if h.topics[topic] == nil {
+		h.topics[topic] = map[*subscription]bool{}
+	}
+
+	sub := &subscription{handler: handler}
+	h.topics[topic][sub] = true
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.topics[topic], sub)
+	}
+}
+
+func (h *handlers) dispatch(topic string, payload []byte) {
+	h.mu.RLock()
+	subs := make([]*subscription, 0, len(h.topics[topic]))
+	for sub := range h.topics[topic] {
+		subs = append(subs, sub)
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.handler(payload)
+	}
+}
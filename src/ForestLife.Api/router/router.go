@@ -23,6 +23,10 @@ func Routes() http.Handler {
 
 	router.Post("/api/v1/users/{id}/follow", controllers.Follow)
 	router.Post("/api/v1/users/{id}/unfollow", controllers.Unfollow)
+	router.Post("/api/v1/users/{id}/block", controllers.Block)
+	router.Post("/api/v1/users/{id}/unblock", controllers.Unblock)
+	router.Post("/api/v1/users/{id}/mute", controllers.Mute)
+	router.Post("/api/v1/users/{id}/unmute", controllers.Unmute)
 
 	router.Post("/api/v1/auth/login", controllers.SignIn)
 	router.Post("/api/v1/auth/register", controllers.SignUp)
@@ -30,11 +34,85 @@ func Routes() http.Handler {
 	router.Post("/api/v1/auth/logout", controllers.Logout)
 
 	router.Get("/api/v1/profile", controllers.GetProfile)
+	router.Patch("/api/v1/profile/settings", controllers.UpdateProfileSettings)
+	router.Put("/api/v1/profile/pins", controllers.ReorderPins)
+	router.Get("/api/v1/profiles/search", controllers.SearchProfiles)
+
+	router.Get("/api/v1/reactions", controllers.GetReactions)
+
+	router.Get("/api/v1/tags/{tag}", controllers.GetTag)
+	router.Get("/api/v1/tags/{tag}/posts", controllers.GetTagPosts)
+
+	router.Get("/api/v1/mentions", controllers.GetMentions)
+
+	router.Get("/api/v1/search/posts", controllers.SearchPosts)
+
+	router.Get("/api/v1/filters", controllers.GetFilters)
+	router.Post("/api/v1/filters", controllers.CreateFilter)
+	router.Put("/api/v1/filters/{id}", controllers.UpdateFilter)
+	router.Delete("/api/v1/filters/{id}", controllers.DeleteFilter)
+
+	router.Get("/api/v1/trends/tags", controllers.GetTrendingTags)
+	router.Get("/api/v1/trends/posts", controllers.GetTrendingPosts)
+	router.Get("/api/v1/trends/excluded_tags", controllers.GetExcludedTags)
+	router.Post("/api/v1/trends/excluded_tags", controllers.ExcludeTag)
+	router.Delete("/api/v1/trends/excluded_tags/{tag}", controllers.IncludeTag)
+
+	router.Get("/api/v1/suggestions/follows", controllers.GetFollowSuggestions)
+	router.Delete("/api/v1/suggestions/follows/{id}", controllers.DismissFollowSuggestion)
+
+	router.Get("/api/v1/bookmarks", controllers.GetBookmarks)
+	router.Get("/api/v1/bookmarks/collections", controllers.GetBookmarkCollections)
+	router.Post("/api/v1/bookmarks/collections", controllers.CreateBookmarkCollection)
+	router.Delete("/api/v1/bookmarks/collections/{id}", controllers.DeleteBookmarkCollection)
+	router.Get("/api/v1/bookmarks/collections/{id}/posts", controllers.GetCollectionBookmarks)
+
+	router.Get("/api/v1/notifications", controllers.GetNotifications)
+	router.Get("/api/v1/notifications/grouped", controllers.GetNotificationGroups)
+	router.Get("/api/v1/notifications/unread_count", controllers.GetUnreadNotificationCount)
+	router.Post("/api/v1/notifications/read_all", controllers.MarkAllNotificationsRead)
+	router.Post("/api/v1/notifications/{id}/read", controllers.MarkNotificationRead)
+
+	router.Post("/api/v1/reports", controllers.CreateReport)
+	router.Get("/api/v1/moderation/reports", controllers.GetReports)
+	router.Get("/api/v1/moderation/reports/{id}", controllers.GetReport)
+	router.Post("/api/v1/moderation/reports/{id}/assign", controllers.AssignReport)
+	router.Post("/api/v1/moderation/reports/{id}/resolve", controllers.ResolveReport)
+	router.Post("/api/v1/moderation/reports/{id}/dismiss", controllers.DismissReport)
+	router.Put("/api/v1/moderation/users/{id}/state", controllers.SetAccountState)
+	router.Get("/api/v1/moderation/held_posts", controllers.GetHeldPostQueue)
+	router.Post("/api/v1/moderation/held_posts/{id}/approve", controllers.ApprovePost)
+	router.Post("/api/v1/moderation/held_posts/{id}/reject", controllers.RejectPost)
+
+	router.Get("/api/v1/timeline/home", controllers.GetHomeTimeline)
+
+	router.Get("/api/v1/streaming", controllers.Streaming)
+
+	router.Get("/api/v1/conversations", controllers.GetConversations)
+	router.Post("/api/v1/conversations", controllers.StartConversation)
+	router.Get("/api/v1/conversations/{id}/messages", controllers.GetMessages)
+	router.Post("/api/v1/conversations/{id}/messages", controllers.SendMessage)
+	router.Post("/api/v1/conversations/{id}/read", controllers.MarkConversationRead)
 
 	router.Post("/api/v1/posts/{id}/unlike", controllers.UnlikePost)
 	router.Post("/api/v1/posts/{id}/like", controllers.LikePost)
+	router.Post("/api/v1/posts/{id}/react", controllers.React)
+	router.Post("/api/v1/posts/{id}/unreact", controllers.Unreact)
+	router.Post("/api/v1/posts/{id}/bookmark", controllers.AddBookmark)
+	router.Post("/api/v1/posts/{id}/unbookmark", controllers.RemoveBookmark)
 	router.Get("/api/v1/posts", controllers.GetPosts)
+	router.Get("/api/v1/posts/drafts", controllers.GetDrafts)
+	router.Get("/api/v1/posts/scheduled", controllers.GetScheduledPosts)
+	router.Get("/api/v1/posts/held", controllers.GetHeldPosts)
+	router.Get("/api/v1/posts/trash", controllers.GetTrash)
+	router.Post("/api/v1/posts/{id}/restore", controllers.RestorePost)
+	router.Post("/api/v1/posts/{id}/pin", controllers.PinPost)
+	router.Post("/api/v1/posts/{id}/unpin", controllers.UnpinPost)
+	router.Post("/api/v1/posts/{id}/poll/votes", controllers.VotePoll)
+	router.Post("/api/v1/posts/{id}/sensitive", controllers.MarkPostSensitive)
+	router.Post("/api/v1/posts/{id}/unschedule", controllers.UnschedulePost)
 	router.Get("/api/v1/posts/{id}", controllers.GetPostById)
+	router.Get("/api/v1/posts/{id}/history", controllers.GetPostHistory)
 	router.Post("/api/v1/posts", controllers.CreatePost)
 	router.Put("/api/v1/posts/{id}", controllers.UpdatePost)
 	router.Delete("/api/v1/posts/{id}", controllers.DeletePost)
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Account states. Limited accounts are only seen by their followers and left
+// out of trends and suggestions; suspended accounts can't sign in and their
+// content is hidden from everyone.
+const (
+	StateActive    = "active"
+	StateLimited   = "limited"
+	StateSuspended = "suspended"
+)
+
+const maxStateReasonLength = 1000
+
+// How often limits and suspensions past their expiry are lifted
+const accountStateInterval = time.Minute
+
+// AccountState is a moderator's restriction on an account. Reason is shown to
+// the account's owner, Note only to other moderators. A nil ExpiresAt lasts
+// until a moderator lifts it.
+type AccountState struct {
+	UserID    string     `json:"user_id"`
+	State     string     `json:"state"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Note      string     `json:"note,omitempty"`
+}
+
+// SuspendedError is returned when a suspended account signs in
+type SuspendedError struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (e *SuspendedError) Error() string {
+	return "accountSuspended"
+}
+
+// stateInEffect reports whether a state expiring at expiresAt still applies.
+// Expired states are reset by expireAccountStates, which may not have run yet.
+func stateInEffect(state string, expiresAt *time.Time) bool {
+	return state != StateActive && (expiresAt == nil || time.Now().Before(*expiresAt))
+}
+
+// notSuspendedClause filters out rows whose authorCol is a suspended account
+func notSuspendedClause(authorCol string) string {
+	return fmt.Sprintf(`
+		AND NOT EXISTS (
+			SELECT 1
+			FROM users restricted
+			WHERE restricted.id = %s
+				AND restricted.state = 'suspended'
+				AND (restricted.state_expires_at IS NULL OR restricted.state_expires_at > NOW())
+		)`, authorCol)
+}
+
+// notLimitedClause filters out rows whose authorCol is a limited account,
+// unless the viewer bound to parameter $viewerParam is that account or
+// follows it. Anonymous viewers are bound as NULL and see no limited accounts.
+func notLimitedClause(authorCol string, viewerParam int) string {
+	return fmt.Sprintf(`
+		AND NOT EXISTS (
+			SELECT 1
+			FROM users restricted
+			WHERE restricted.id = %[1]s
+				AND restricted.state = 'limited'
+				AND (restricted.state_expires_at IS NULL OR restricted.state_expires_at > NOW())
+				AND restricted.id IS DISTINCT FROM $%[2]d::uuid
+				AND NOT EXISTS (
+					SELECT 1
+					FROM follow_relationships
+					WHERE follower_id = $%[2]d::uuid AND followee_id = restricted.id
+				)
+		)`, authorCol, viewerParam)
+}
+
+// validateAccountState checks a state change and returns its trimmed reason
+func validateAccountState(change AccountState) (AccountState, error) {
+	if change.State != StateActive && change.State != StateLimited && change.State != StateSuspended {
+		return change, errors.New("invalidState")
+	}
+
+	change.Reason = strings.TrimSpace(change.Reason)
+
+	if utf8.RuneCountInString(change.Reason) > maxStateReasonLength {
+		return change, errors.New("reasonTooLong")
+	}
+
+	if change.State == StateActive {
+		change.Reason = ""
+		change.ExpiresAt = nil
+	}
+
+	if change.ExpiresAt != nil && !change.ExpiresAt.After(time.Now()) {
+		return change, errors.New("expiryInPast")
+	}
+
+	return change, nil
+}
+
+// canModerate checks that moderatorId may act against targetId. Moderators
+// can't act against other staff; only admins can.
+func canModerate(ctx context.Context, tx *sql.Tx, moderatorId string, targetId string) (int, error) {
+	var targetRole, callerRole string
+
+	err := tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, targetId).Scan(&targetRole)
+
+	if err == sql.ErrNoRows {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, moderatorId).Scan(&callerRole)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if targetRole != RoleUser && callerRole != RoleAdmin {
+		return http.StatusForbidden, errors.New("cannotModerateStaff")
+	}
+
+	return http.StatusOK, nil
+}
+
+// setAccountState changes an account's state. Suspending an account also
+// signs it out everywhere.
+func setAccountState(ctx context.Context, tx *sql.Tx, userId string, change AccountState) error {
+	query := `
+		UPDATE users
+		SET state = $2, state_reason = $3, state_expires_at = $4
+		WHERE id = $1
+	`
+
+	_, err := tx.ExecContext(ctx, query, userId, change.State, change.Reason, change.ExpiresAt)
+
+	if err != nil {
+		return err
+	}
+
+	if change.State != StateSuspended {
+		return nil
+	}
+
+	sessionQuery := `
+		DELETE FROM sessions
+		WHERE username = (
+			SELECT profiles.username
+			FROM users
+			INNER JOIN profiles ON users.profile_id = profiles.id
+			WHERE users.id = $1
+		)
+	`
+
+	_, err = tx.ExecContext(ctx, sessionQuery, userId)
+
+	return err
+}
+
+func loadAccountState(ctx context.Context, userId string) (*AccountState, error) {
+	query := `
+		SELECT id, state, state_reason, state_expires_at
+		FROM users
+		WHERE id = $1
+	`
+
+	var state AccountState
+	err := db.QueryRowContext(ctx, query, userId).Scan(&state.UserID, &state.State, &state.Reason, &state.ExpiresAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// SetAccountState limits, suspends or restores an account outside of a
+// report. The change is recorded with the note.
+func (a *AccountState) SetAccountState(userId string, change AccountState, sessionId string) (*AccountState, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	moderatorId, code, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return nil, code, err
+	}
+
+	if userId == moderatorId {
+		return nil, http.StatusBadRequest, errors.New("cannotModerateSelf")
+	}
+
+	change, err = validateAccountState(change)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	note, err := validateNote(change.Note)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	if code, err := canModerate(ctx, tx, moderatorId, userId); err != nil {
+		return nil, code, err
+	}
+
+	if err := setAccountState(ctx, tx, userId, change); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToSetState")
+	}
+
+	action := map[string]string{
+		StateActive:    ActionRestore,
+		StateLimited:   ActionLimit,
+		StateSuspended: ActionSuspend,
+	}[change.State]
+
+	if err := recordAccountAction(ctx, tx, userId, moderatorId, action, note); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToSetState")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToSetState")
+	}
+
+	// Trends are cached with or without the account's activity in them
+	invalidate(ctx, trendsCacheKey)
+
+	state, err := loadAccountState(ctx, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return state, http.StatusOK, nil
+}
+
+// expireAccountStates lifts limits and suspensions whose expiry has passed
+func expireAccountStates(ctx context.Context) error {
+	query := `
+		UPDATE users
+		SET state = 'active', state_reason = '', state_expires_at = NULL
+		WHERE state_expires_at <= NOW()
+	`
+
+	result, err := db.ExecContext(ctx, query)
+
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		invalidate(ctx, trendsCacheKey)
+	}
+
+	return nil
+}
@@ -115,23 +115,46 @@ func (a *Auth) GetUserId(ctx context.Context, sessionId string) (string, error)
 	}
 
 	profileQuery := `
-		SELECT users.id
+		SELECT users.id, users.state, users.state_expires_at
 		FROM users
 		INNER JOIN profiles ON users.profile_id = profiles.id
 		WHERE profiles.username = $1
 	`
 
-	var userId string
+	var userId, state string
+	var expiresAt *time.Time
 	row = db.QueryRowContext(ctx, profileQuery, username)
-	err = row.Scan(&userId)
+	err = row.Scan(&userId, &state, &expiresAt)
 
 	if err != nil {
 		return "", errors.New("serverError")
 	}
 
+	// Suspending an account revokes its sessions, but one could have been
+	// created while the suspension was being applied
+	if state == StateSuspended && stateInEffect(state, expiresAt) {
+		return "", &SuspendedError{}
+	}
+
 	return userId, nil
 }
 
+// viewerId resolves the signed-in user for endpoints that also serve
+// anonymous callers, returning an empty string when there is no valid session
+func viewerId(ctx context.Context, sessionId string) string {
+	if sessionId == "" {
+		return ""
+	}
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return ""
+	}
+
+	return userId
+}
+
 func (a *Auth) GetSessionId(r *http.Request) (string, error) {
 	c, err := r.Cookie("session_token")
 
@@ -229,15 +252,16 @@ func (a *Auth) SignIn(creds Credentials) (*http.Cookie, int, error) {
 
 	// Search for user
 	query := `
-		SELECT password
+		SELECT password, users.state, users.state_reason, users.state_expires_at
 		FROM profiles
 		INNER JOIN users ON profiles.id = users.profile_id
 		WHERE profiles.username = $1
 	`
 
-	var storedHash string
+	var storedHash, state, reason string
+	var suspendedUntil *time.Time
 	row := db.QueryRowContext(ctx, query, creds.Username)
-	err := row.Scan(&storedHash)
+	err := row.Scan(&storedHash, &state, &reason, &suspendedUntil)
 
 	if err != nil {
 		return &cookie, http.StatusBadRequest, errors.New("serverError")
@@ -247,6 +271,11 @@ func (a *Auth) SignIn(creds Credentials) (*http.Cookie, int, error) {
 		return &cookie, http.StatusUnauthorized, errors.New("invalidPassword")
 	}
 
+	// Only the account's owner learns why it was suspended
+	if state == StateSuspended && stateInEffect(state, suspendedUntil) {
+		return &cookie, http.StatusForbidden, &SuspendedError{Reason: reason, ExpiresAt: suspendedUntil}
+	}
+
 	sessionToken, expiresAt, err := createSession(ctx, creds.Username)
 
 	cookie = http.Cookie{
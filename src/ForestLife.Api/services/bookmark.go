@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const maxCollectionNameLength = 100
+
+type Bookmark struct {
+	CollectionID *string `json:"collection_id"`
+}
+
+type BookmarkCollection struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	BookmarkCount int       `json:"bookmark_count"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// loadBookmarks marks the posts the viewer has bookmarked
+func loadBookmarks(ctx context.Context, posts []*Post, viewerId string) error {
+	if len(posts) == 0 || viewerId == "" {
+		return nil
+	}
+
+	byId := make(map[string]*Post, len(posts))
+	ids := make([]string, 0, len(posts))
+
+	for _, p := range posts {
+		byId[p.ID] = p
+		ids = append(ids, p.ID)
+	}
+
+	query := `
+		SELECT post_id
+		FROM bookmarks
+		WHERE user_id = $1 AND post_id = ANY($2::uuid[])
+	`
+
+	rows, err := db.QueryContext(ctx, query, viewerId, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId string
+
+		if err := rows.Scan(&postId); err != nil {
+			return err
+		}
+
+		byId[postId].Bookmarked = true
+	}
+
+	return rows.Err()
+}
+
+// ownsCollection reports whether collectionId exists and belongs to userId
+func ownsCollection(ctx context.Context, userId string, collectionId string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM bookmark_collections
+			WHERE id = $1 AND user_id = $2
+		)
+	`
+
+	var owned bool
+	err := db.QueryRowContext(ctx, query, collectionId, userId).Scan(&owned)
+
+	return owned, err
+}
+
+// AddBookmark bookmarks a post, or moves an existing bookmark, into the
+// given collection. A nil collection leaves the bookmark unsorted.
+func (b *Bookmark) AddBookmark(postId string, bookmark Bookmark, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	var collection sql.NullString
+
+	if bookmark.CollectionID != nil {
+		owned, err := ownsCollection(ctx, userId, *bookmark.CollectionID)
+
+		if err != nil || !owned {
+			return http.StatusNotFound, errors.New("collectionNotFound")
+		}
+
+		collection = sql.NullString{String: *bookmark.CollectionID, Valid: true}
+	}
+
+	query := `
+		INSERT INTO bookmarks (user_id, post_id, collection_id)
+		SELECT $1, p.id, $3
+		FROM posts p
+		WHERE p.id = $2` + visibleClause("p") + `
+		ON CONFLICT ON CONSTRAINT UQ_bookmarks DO UPDATE
+		SET collection_id = EXCLUDED.collection_id
+	`
+
+	result, err := db.ExecContext(ctx, query, userId, postId, collection)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToBookmark")
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	return http.StatusOK, nil
+}
+
+func (b *Bookmark) RemoveBookmark(postId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		DELETE FROM bookmarks
+		WHERE user_id = $1 AND post_id = $2
+	`
+
+	_, err = db.ExecContext(ctx, query, userId, postId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToRemoveBookmark")
+	}
+
+	return http.StatusOK, nil
+}
+
+// GetBookmarks pages through the caller's bookmarked posts, most recently
+// bookmarked first. An empty collectionId lists bookmarks from every
+// collection.
+func (b *Bookmark) GetBookmarks(collectionId string, page Page, sessionId string) ([]*Post, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, "", http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	args := []interface{}{userId}
+	filters := ""
+
+	if collectionId != "" {
+		owned, err := ownsCollection(ctx, userId, collectionId)
+
+		if err != nil || !owned {
+			return nil, "", http.StatusNotFound, errors.New("collectionNotFound")
+		}
+
+		args = append(args, collectionId)
+		filters += fmt.Sprintf(" AND b.collection_id = $%d", len(args))
+	}
+
+	after, args, err := page.keyset(args, "b.created_at", "b.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT ` + postColumns + `, b.created_at, b.id
+		FROM bookmarks b
+		INNER JOIN posts p ON p.id = b.post_id
+		WHERE b.user_id = $1` + visibleClause("p") + notLimitedClause("p.author_id", 1) + filters + after + `
+		ORDER BY b.created_at DESC, b.id DESC
+	` + page.limitClause()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	posts := []*Post{}
+	cursors := []string{}
+
+	for rows.Next() {
+		var savedAt time.Time
+		var bookmarkId string
+
+		post, err := scanPost(bookmarkScanner{rows, &savedAt, &bookmarkId})
+
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		posts = append(posts, post)
+		cursors = append(cursors, timeCursor(savedAt, bookmarkId))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	// Bookmarks page by when they were saved, not when the post was written
+	next := ""
+	if page.hasMore(len(posts)) {
+		posts = posts[:page.size()]
+		next = cursors[page.size()-1]
+	}
+
+	err = hydratePosts(ctx, posts, userId)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return posts, next, http.StatusOK, nil
+}
+
+type bookmarkScanner struct {
+	rows       *sql.Rows
+	savedAt    *time.Time
+	bookmarkId *string
+}
+
+func (s bookmarkScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append(dest, s.savedAt, s.bookmarkId)...)
+}
+
+func (b *Bookmark) GetCollections(sessionId string) ([]*BookmarkCollection, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		SELECT c.id, c.name, COUNT(b.id), c.created_at
+		FROM bookmark_collections c
+		LEFT JOIN bookmarks b ON b.collection_id = c.id
+		WHERE c.user_id = $1
+		GROUP BY c.id
+		ORDER BY c.name
+	`
+
+	rows, err := db.QueryContext(ctx, query, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	collections := []*BookmarkCollection{}
+
+	for rows.Next() {
+		var collection BookmarkCollection
+
+		err := rows.Scan(&collection.ID, &collection.Name, &collection.BookmarkCount, &collection.CreatedAt)
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return collections, http.StatusOK, nil
+}
+
+func (b *Bookmark) CreateCollection(name string, sessionId string) (*BookmarkCollection, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return nil, http.StatusBadRequest, errors.New("nameRequired")
+	}
+
+	if utf8.RuneCountInString(name) > maxCollectionNameLength {
+		return nil, http.StatusBadRequest, errors.New("nameTooLong")
+	}
+
+	query := `
+		INSERT INTO bookmark_collections (user_id, name)
+		VALUES ($1, $2)
+		ON CONFLICT ON CONSTRAINT UQ_bookmark_collections DO NOTHING
+		RETURNING id, name, created_at
+	`
+
+	var collection BookmarkCollection
+	err = db.QueryRowContext(ctx, query, userId, name).Scan(&collection.ID, &collection.Name, &collection.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusConflict, errors.New("collectionExists")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToCreateCollection")
+	}
+
+	return &collection, http.StatusOK, nil
+}
+
+// DeleteCollection removes a collection. Its bookmarks are kept, unsorted.
+func (b *Bookmark) DeleteCollection(collectionId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		DELETE FROM bookmark_collections
+		WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := db.ExecContext(ctx, query, collectionId, userId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToDeleteCollection")
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	return http.StatusOK, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/itsjoetree/forest-life/pubsub"
+)
+
+const (
+	// Stream events for connected clients
+	topicStream = "stream"
+
+	// Post events, which each replica hydrates and addresses itself since a
+	// post's audience can be too large for one message
+	topicPost = "stream.post"
+
+	// Keys of cached data that changed and must be reloaded
+	topicInvalidate = "cache.invalidate"
+)
+
+var bus pubsub.PubSub
+var unsubscribeBus []func()
+
+// UsePubSub routes real-time events and cache invalidation through ps, so
+// that they reach every replica sharing the same backend
+func UsePubSub(ps pubsub.PubSub) {
+	for _, unsubscribe := range unsubscribeBus {
+		unsubscribe()
+	}
+
+	bus = ps
+	startPostDelivery.Do(startPostWorkers)
+
+	unsubscribeBus = []func(){
+		bus.Subscribe(topicStream, func(payload []byte) {
+			var event StreamEvent
+
+			if json.Unmarshal(payload, &event) == nil {
+				streams.publish(event)
+			}
+		}),
+		bus.Subscribe(topicPost, func(payload []byte) {
+			var event postEvent
+
+			if json.Unmarshal(payload, &event) == nil {
+				queuePost(event)
+			}
+		}),
+		bus.Subscribe(topicInvalidate, func(payload []byte) {
+			var key string
+
+			if json.Unmarshal(payload, &key) == nil {
+				caches.invalidate(key)
+			}
+		}),
+	}
+}
+
+// invalidate tells every replica that the cached data under key is stale
+func invalidate(ctx context.Context, key string) error {
+	payload, err := json.Marshal(key)
+
+	if err != nil {
+		return err
+	}
+
+	return bus.Publish(ctx, topicInvalidate, payload)
+}
@@ -0,0 +1,28 @@
+package services
+
+import "sync"
+
+// cacheRegistry maps cache keys to the functions that drop the cached data,
+// so an invalidation published by any replica clears it on all of them
+type cacheRegistry struct {
+	mu      sync.RWMutex
+	onClear map[string][]func()
+}
+
+var caches = &cacheRegistry{onClear: map[string][]func(){}}
+
+func (c *cacheRegistry) register(key string, clear func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onClear[key] = append(c.onClear[key], clear)
+}
+
+func (c *cacheRegistry) invalidate(key string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, clear := range c.onClear[key] {
+		clear()
+	}
+}
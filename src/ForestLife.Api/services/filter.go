@@ -0,0 +1,480 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// Places a keyword filter applies. Replies are posts that mention the user.
+const (
+	FilterHome          = "home"
+	FilterNotifications = "notifications"
+	FilterReplies       = "replies"
+	FilterSearch        = "search"
+)
+
+var filterContexts = []string{FilterHome, FilterNotifications, FilterReplies, FilterSearch}
+
+// What happens to content matching a filter: it is left out entirely, or
+// returned with a filtered annotation so clients can show a placeholder
+const (
+	FilterActionHide = "hide"
+	FilterActionWarn = "warn"
+)
+
+const maxFilterPhraseLength = 100
+const maxFiltersPerUser = 100
+
+// KeywordFilter mutes a word or phrase. A whole word filter matches the
+// phrase only where it isn't part of a longer word. Filters past ExpiresAt
+// are kept but no longer applied.
+type KeywordFilter struct {
+	ID        string     `json:"id"`
+	Phrase    string     `json:"phrase"`
+	WholeWord bool       `json:"whole_word"`
+	Contexts  []string   `json:"contexts"`
+	Action    string     `json:"action"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// FilterResult names a filter that matched a post or notification
+type FilterResult struct {
+	FilterID string `json:"filter_id"`
+	Phrase   string `json:"phrase"`
+}
+
+// keywordMatcher applies a user's filters for one context
+type keywordMatcher struct {
+	userId   string
+	filters  []*KeywordFilter
+	patterns []*regexp.Regexp
+}
+
+func isFilterContext(where string) bool {
+	for _, c := range filterContexts {
+		if c == where {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateFilter checks a filter and returns it with its phrase trimmed and
+// contexts deduplicated
+func validateFilter(filter KeywordFilter) (KeywordFilter, error) {
+	filter.Phrase = strings.TrimSpace(filter.Phrase)
+
+	if filter.Phrase == "" {
+		return filter, errors.New("phraseRequired")
+	}
+
+	if utf8.RuneCountInString(filter.Phrase) > maxFilterPhraseLength {
+		return filter, errors.New("phraseTooLong")
+	}
+
+	if filter.Action == "" {
+		filter.Action = FilterActionWarn
+	}
+
+	if filter.Action != FilterActionHide && filter.Action != FilterActionWarn {
+		return filter, errors.New("invalidAction")
+	}
+
+	seen := map[string]bool{}
+	contexts := []string{}
+
+	for _, where := range filter.Contexts {
+		if !isFilterContext(where) {
+			return filter, errors.New("invalidContext")
+		}
+
+		if !seen[where] {
+			seen[where] = true
+			contexts = append(contexts, where)
+		}
+	}
+
+	if len(contexts) == 0 {
+		return filter, errors.New("contextRequired")
+	}
+
+	filter.Contexts = contexts
+
+	if filter.ExpiresAt != nil && !filter.ExpiresAt.After(time.Now()) {
+		return filter, errors.New("expiryInPast")
+	}
+
+	return filter, nil
+}
+
+// filterPattern compiles the case-insensitive pattern a filter matches. A
+// whole word phrase can't have a letter, digit or underscore on either side.
+func filterPattern(filter *KeywordFilter) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(filter.Phrase)
+
+	if filter.WholeWord {
+		pattern = `(?:^|[^\pL\pN_])` + pattern + `(?:[^\pL\pN_]|$)`
+	}
+
+	return regexp.MustCompile(`(?i)` + pattern)
+}
+
+const filterColumns = `id, phrase, whole_word, array_to_string(contexts, ','), action, expires_at, created_at, updated_at`
+
+func scanFilter(row rowScanner) (*KeywordFilter, error) {
+	var filter KeywordFilter
+	var contexts string
+
+	err := row.Scan(
+		&filter.ID,
+		&filter.Phrase,
+		&filter.WholeWord,
+		&contexts,
+		&filter.Action,
+		&filter.ExpiresAt,
+		&filter.CreatedAt,
+		&filter.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filter.Contexts = strings.Split(contexts, ",")
+
+	return &filter, nil
+}
+
+// loadMatcher loads userId's unexpired filters for the context where
+func loadMatcher(ctx context.Context, userId string, where string) (*keywordMatcher, error) {
+	matcher := &keywordMatcher{userId: userId}
+
+	if userId == "" {
+		return matcher, nil
+	}
+
+	query := `
+		SELECT ` + filterColumns + `
+		FROM keyword_filters
+		WHERE user_id = $1
+			AND $2 = ANY(contexts)
+			AND (expires_at IS NULL OR expires_at > NOW())
+	`
+
+	rows, err := db.QueryContext(ctx, query, userId, where)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		filter, err := scanFilter(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		matcher.filters = append(matcher.filters, filter)
+		matcher.patterns = append(matcher.patterns, filterPattern(filter))
+	}
+
+	return matcher, rows.Err()
+}
+
+// match returns the filters text matches, and whether any of them hides it
+func (m *keywordMatcher) match(text string) ([]FilterResult, bool) {
+	var results []FilterResult
+	hide := false
+
+	for i, pattern := range m.patterns {
+		if !pattern.MatchString(text) {
+			continue
+		}
+
+		results = append(results, FilterResult{FilterID: m.filters[i].ID, Phrase: m.filters[i].Phrase})
+		hide = hide || m.filters[i].Action == FilterActionHide
+	}
+
+	return results, hide
+}
+
+// filterableText is everything in a post a filter is matched against: its
+// text as displayed, its content warning and its poll options, one per line
+func filterableText(post *Post) string {
+	parts := []string{string(parseRichText(post.Text).display), post.SpoilerText}
+
+	if post.Poll != nil {
+		for _, option := range post.Poll.Options {
+			parts = append(parts, option.Title)
+		}
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// filterPosts drops the posts a hide filter matches and annotates those a
+// warn filter matches. Users' own posts are never filtered.
+func (m *keywordMatcher) filterPosts(posts []*Post) []*Post {
+	if len(m.filters) == 0 {
+		return posts
+	}
+
+	kept := make([]*Post, 0, len(posts))
+
+	for _, post := range posts {
+		if post.AuthorID == m.userId {
+			kept = append(kept, post)
+			continue
+		}
+
+		results, hide := m.match(filterableText(post))
+
+		if hide {
+			continue
+		}
+
+		post.Filtered = results
+		kept = append(kept, post)
+	}
+
+	return kept
+}
+
+// applyFilters filters posts, already hydrated, with viewerId's filters for
+// the context where. Anonymous viewers have no filters.
+func applyFilters(ctx context.Context, posts []*Post, viewerId string, where string) ([]*Post, error) {
+	matcher, err := loadMatcher(ctx, viewerId, where)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return matcher.filterPosts(posts), nil
+}
+
+// filterNotificationPosts matches userId's notification filters against the
+// posts notifications are about. It returns the filters each post matched
+// and the posts that are hidden. Notifications about the user's own posts,
+// like likes and reactions, are never filtered.
+func filterNotificationPosts(ctx context.Context, userId string, postIds []string) (map[string][]FilterResult, map[string]bool, error) {
+	results := map[string][]FilterResult{}
+	hidden := map[string]bool{}
+
+	matcher, err := loadMatcher(ctx, userId, FilterNotifications)
+
+	if err != nil || len(matcher.filters) == 0 || len(postIds) == 0 {
+		return results, hidden, err
+	}
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		WHERE p.id = ANY($1::uuid[]) AND p.author_id <> $2
+	`
+
+	posts, err := queryPosts(ctx, query, pq.Array(postIds), userId)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := loadPolls(ctx, posts, userId); err != nil {
+		return nil, nil, err
+	}
+
+	for _, post := range posts {
+		matched, hide := matcher.match(filterableText(post))
+
+		if hide {
+			hidden[post.ID] = true
+		} else if len(matched) > 0 {
+			results[post.ID] = matched
+		}
+	}
+
+	return results, hidden, nil
+}
+
+func (f *KeywordFilter) GetFilters(sessionId string) ([]*KeywordFilter, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		SELECT ` + filterColumns + `
+		FROM keyword_filters
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := db.QueryContext(ctx, query, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	filters := []*KeywordFilter{}
+
+	for rows.Next() {
+		filter, err := scanFilter(rows)
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		filters = append(filters, filter)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return filters, http.StatusOK, nil
+}
+
+func (f *KeywordFilter) CreateFilter(filter KeywordFilter, sessionId string) (*KeywordFilter, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	filter, err = validateFilter(filter)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	var count int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM keyword_filters WHERE user_id = $1`, userId).Scan(&count)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if count >= maxFiltersPerUser {
+		return nil, http.StatusBadRequest, errors.New("tooManyFilters")
+	}
+
+	query := `
+		INSERT INTO keyword_filters (user_id, phrase, whole_word, contexts, action, expires_at)
+		VALUES ($1, $2, $3, $4::text[], $5, $6)
+		ON CONFLICT (user_id, lower(phrase)) DO NOTHING
+		RETURNING ` + filterColumns
+
+	created, err := scanFilter(db.QueryRowContext(ctx, query, userId, filter.Phrase, filter.WholeWord, pq.Array(filter.Contexts), filter.Action, filter.ExpiresAt))
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusConflict, errors.New("filterExists")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToCreateFilter")
+	}
+
+	return created, http.StatusOK, nil
+}
+
+// UpdateFilter replaces every setting of one of the caller's filters
+func (f *KeywordFilter) UpdateFilter(id string, filter KeywordFilter, sessionId string) (*KeywordFilter, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	filter, err = validateFilter(filter)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	var exists, taken bool
+
+	existsQuery := `
+		SELECT
+			EXISTS (SELECT 1 FROM keyword_filters WHERE id = $1 AND user_id = $2),
+			EXISTS (SELECT 1 FROM keyword_filters WHERE id <> $1 AND user_id = $2 AND lower(phrase) = lower($3))
+	`
+
+	err = db.QueryRowContext(ctx, existsQuery, id, userId, filter.Phrase).Scan(&exists, &taken)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if !exists {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if taken {
+		return nil, http.StatusConflict, errors.New("filterExists")
+	}
+
+	query := `
+		UPDATE keyword_filters
+		SET phrase = $3, whole_word = $4, contexts = $5::text[], action = $6, expires_at = $7, updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING ` + filterColumns
+
+	updated, err := scanFilter(db.QueryRowContext(ctx, query, id, userId, filter.Phrase, filter.WholeWord, pq.Array(filter.Contexts), filter.Action, filter.ExpiresAt))
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToUpdateFilter")
+	}
+
+	return updated, http.StatusOK, nil
+}
+
+func (f *KeywordFilter) DeleteFilter(id string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	result, err := db.ExecContext(ctx, `DELETE FROM keyword_filters WHERE id = $1 AND user_id = $2`, id, userId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToDeleteFilter")
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	return http.StatusOK, nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/itsjoetree/forest-life/unfurl"
+	"github.com/lib/pq"
+)
+
+const maxLinkLength = 2048
+
+// How often links without a preview are looked for and fetched
+const linkPreviewInterval = 10 * time.Second
+
+// Links fetched per run. They are fetched at the same time, each bounded by
+// the fetcher's own timeout.
+const linkPreviewBatch = 10
+
+// A claimed link still not fetched after this long is assumed abandoned by
+// the replica that claimed it and is fetched again
+const linkPreviewClaimTimeout = 2 * time.Minute
+
+var linkFetcher = unfurl.New(unfurl.Options{})
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// LinkPreview is the card shown for the first link in a post
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+}
+
+// extractLink returns the first link in text, or an empty string
+func extractLink(text string) string {
+	for _, span := range parseRichText(text).spans {
+		if span.kind == EntityLink {
+			return span.value
+		}
+	}
+
+	return ""
+}
+
+// linkSpans finds the http and https links in text, as rune offsets.
+// Punctuation ending a sentence is not part of a link.
+func linkSpans(text string) []richSpan {
+	var spans []richSpan
+
+	for _, match := range linkPattern.FindAllStringIndex(text, -1) {
+		link := strings.TrimRight(text[match[0]:match[1]], ".,!?:;'*_`")
+
+		// Keep the closing parenthesis of links like .../Oak_(tree)
+		for strings.HasSuffix(link, ")") && strings.Count(link, "(") < strings.Count(link, ")") {
+			link = strings.TrimSuffix(link, ")")
+		}
+
+		if len(link) > maxLinkLength {
+			continue
+		}
+
+		start := utf8.RuneCountInString(text[:match[0]])
+
+		spans = append(spans, richSpan{
+			kind:  EntityLink,
+			start: start,
+			end:   start + utf8.RuneCountInString(link),
+			value: link,
+		})
+	}
+
+	return spans
+}
+
+// syncLink records the link a post's preview card is made from. The preview
+// itself is fetched later by fetchLinkPreviews.
+func syncLink(ctx context.Context, tx *sql.Tx, postId string, text string) error {
+	link := extractLink(text)
+
+	if link == "" {
+		_, err := tx.ExecContext(ctx, `DELETE FROM post_links WHERE post_id = $1`, postId)
+		return err
+	}
+
+	query := `
+		INSERT INTO post_links (post_id, url)
+		VALUES ($1, $2)
+		ON CONFLICT (post_id) DO UPDATE
+		SET url = EXCLUDED.url
+	`
+
+	_, err := tx.ExecContext(ctx, query, postId, link)
+
+	return err
+}
+
+// loadLinkPreviews attaches the preview card of each post's link once it has
+// been fetched
+func loadLinkPreviews(ctx context.Context, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byId := make(map[string]*Post, len(posts))
+	ids := make([]string, 0, len(posts))
+
+	for _, p := range posts {
+		p.Card = nil
+		byId[p.ID] = p
+		ids = append(ids, p.ID)
+	}
+
+	query := `
+		SELECT post_links.post_id, link_previews.url, link_previews.title,
+			link_previews.description, link_previews.image, link_previews.site_name
+		FROM post_links
+		INNER JOIN link_previews ON link_previews.url = post_links.url
+		WHERE post_links.post_id = ANY($1::uuid[])
+			AND link_previews.fetched_at IS NOT NULL
+			AND NOT link_previews.failed
+	`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId string
+		var card LinkPreview
+
+		err := rows.Scan(&postId, &card.URL, &card.Title, &card.Description, &card.Image, &card.SiteName)
+
+		if err != nil {
+			return err
+		}
+
+		// A page with nothing to show makes no card
+		if card.Title == "" && card.Description == "" && card.Image == "" {
+			continue
+		}
+
+		byId[postId].Card = &card
+	}
+
+	return rows.Err()
+}
+
+// fetchLinkPreviews fetches previews for links in published posts that don't
+// have one yet. A link is claimed by inserting its row, so each is fetched
+// by one replica; failures are cached too, so broken links aren't retried.
+func fetchLinkPreviews(ctx context.Context) error {
+	claimQuery := `
+		INSERT INTO link_previews (url)
+		SELECT DISTINCT post_links.url
+		FROM post_links
+		INNER JOIN posts p ON p.id = post_links.post_id
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM link_previews
+			WHERE link_previews.url = post_links.url
+		)` + visibleClause("p") + `
+		LIMIT $1
+		ON CONFLICT (url) DO NOTHING
+		RETURNING url
+	`
+
+	links, err := claimLinks(ctx, claimQuery, linkPreviewBatch)
+
+	if err != nil {
+		return err
+	}
+
+	reclaimQuery := `
+		UPDATE link_previews
+		SET claimed_at = NOW()
+		WHERE url IN (
+			SELECT url
+			FROM link_previews
+			WHERE fetched_at IS NULL AND claimed_at < $1
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING url
+	`
+
+	abandoned, err := claimLinks(ctx, reclaimQuery, time.Now().Add(-linkPreviewClaimTimeout), linkPreviewBatch)
+
+	if err != nil {
+		return err
+	}
+
+	links = append(links, abandoned...)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(links))
+
+	for i, link := range links {
+		wg.Add(1)
+
+		go func(i int, link string) {
+			defer wg.Done()
+			errs[i] = storeLinkPreview(ctx, link)
+		}(i, link)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func claimLinks(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var links []string
+
+	for rows.Next() {
+		var link string
+
+		if err := rows.Scan(&link); err != nil {
+			return nil, err
+		}
+
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// storeLinkPreview fetches a claimed link and saves what was found, or that
+// nothing could be
+func storeLinkPreview(ctx context.Context, link string) error {
+	preview, err := linkFetcher.Fetch(ctx, link)
+
+	if err != nil {
+		_, err = db.ExecContext(ctx, `UPDATE link_previews SET failed = TRUE, fetched_at = NOW() WHERE url = $1`, link)
+		return err
+	}
+
+	query := `
+		UPDATE link_previews
+		SET title = $2, description = $3, image = $4, site_name = $5, failed = FALSE, fetched_at = NOW()
+		WHERE url = $1
+	`
+
+	_, err = db.ExecContext(ctx, query, link, preview.Title, preview.Description, preview.Image, preview.SiteName)
+
+	return err
+}
@@ -3,6 +3,8 @@ package services
 import (
 	"database/sql"
 	"time"
+
+	"github.com/itsjoetree/forest-life/pubsub"
 )
 
 var db *sql.DB
@@ -16,5 +18,6 @@ type Models struct {
 
 func New(dbPool *sql.DB) Models {
 	db = dbPool
+	UsePubSub(pubsub.NewMemory())
 	return Models{}
 }
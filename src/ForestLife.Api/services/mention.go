@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+type Mention struct{}
+
+// Entity marks a range of a post's display text that clients should render
+// specially. Start and End are UTF-16 offsets, End exclusive, so they can be
+// used directly on JavaScript, Swift and Java strings.
+type Entity struct {
+	Type     string `json:"type"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+func isUsernameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// mentionSpans finds @username spans in runes. The @ must not follow a word
+// character, so email addresses like ana@forest.life are not mentions.
+func mentionSpans(runes []rune) []richSpan {
+	var spans []richSpan
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' {
+			continue
+		}
+
+		if i > 0 && (isUsernameRune(runes[i-1]) || runes[i-1] == '@') {
+			continue
+		}
+
+		end := i + 1
+
+		for end < len(runes) && isUsernameRune(runes[end]) {
+			end++
+		}
+
+		if end == i+1 {
+			continue
+		}
+
+		spans = append(spans, richSpan{
+			kind:  EntityMention,
+			start: i,
+			end:   end,
+			value: string(runes[i+1 : end]),
+		})
+
+		i = end - 1
+	}
+
+	return spans
+}
+
+// mentionedUsernames returns the lowercase usernames mentioned in text.
+// Mentions inside links and code spans don't count.
+func mentionedUsernames(text string) []string {
+	var usernames []string
+	seen := map[string]bool{}
+
+	for _, span := range parseRichText(text).spans {
+		if span.kind != EntityMention {
+			continue
+		}
+
+		username := strings.ToLower(span.value)
+
+		if !seen[username] {
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+	}
+
+	return usernames
+}
+
+// syncMentions resolves the @usernames in a post's text against profiles and
+// stores a link for each user found, removing links no longer in the text.
+// It returns the users that were newly mentioned.
+func syncMentions(ctx context.Context, tx *sql.Tx, postId string, text string) ([]string, error) {
+	usernames := mentionedUsernames(text)
+	var mentioned []string
+
+	if len(usernames) > 0 {
+		linkQuery := `
+			INSERT INTO post_mentions (post_id, user_id)
+			SELECT $1, users.id
+			FROM users
+			INNER JOIN profiles ON users.profile_id = profiles.id
+			WHERE lower(profiles.username) = ANY($2::text[])
+			ON CONFLICT DO NOTHING
+			RETURNING user_id
+		`
+
+		rows, err := tx.QueryContext(ctx, linkQuery, postId, pq.Array(usernames))
+
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var userId string
+
+			if err := rows.Scan(&userId); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			mentioned = append(mentioned, userId)
+		}
+
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	unlinkQuery := `
+		DELETE FROM post_mentions
+		WHERE post_id = $1 AND user_id NOT IN (
+			SELECT users.id
+			FROM users
+			INNER JOIN profiles ON users.profile_id = profiles.id
+			WHERE lower(profiles.username) = ANY($2::text[])
+		)
+	`
+
+	_, err := tx.ExecContext(ctx, unlinkQuery, postId, pq.Array(usernames))
+
+	return mentioned, err
+}
+
+// postMentionedUsers returns the users mentioned in a post
+func postMentionedUsers(ctx context.Context, postId string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM post_mentions WHERE post_id = $1`, postId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var mentioned []string
+
+	for rows.Next() {
+		var userId string
+
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+
+		mentioned = append(mentioned, userId)
+	}
+
+	return mentioned, rows.Err()
+}
+
+// loadEntities parses each post's text into its display text, entities and
+// HTML rendering. Mentions only become entities when they resolved to a user
+// when the post was saved.
+func loadEntities(ctx context.Context, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(posts))
+
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+
+	query := `
+		SELECT post_mentions.post_id, users.id, profiles.username
+		FROM post_mentions
+		INNER JOIN users ON users.id = post_mentions.user_id
+		INNER JOIN profiles ON users.profile_id = profiles.id
+		WHERE post_mentions.post_id = ANY($1::uuid[])
+	`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	resolved := map[string]map[string]Entity{}
+
+	for rows.Next() {
+		var postId string
+		var mention Entity
+
+		err := rows.Scan(&postId, &mention.UserID, &mention.Username)
+
+		if err != nil {
+			return err
+		}
+
+		if resolved[postId] == nil {
+			resolved[postId] = map[string]Entity{}
+		}
+
+		resolved[postId][strings.ToLower(mention.Username)] = mention
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		text := parseRichText(post.Text)
+
+		post.DisplayText = string(text.display)
+		post.Entities, post.HTML = text.render(resolved[post.ID])
+	}
+
+	return nil
+}
+
+func (m *Mention) GetMentions(page Page, sessionId string) ([]*Post, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, "", http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	args := []interface{}{userId}
+	after, args, err := page.keyset(args, "p.created_at", "p.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		INNER JOIN post_mentions ON post_mentions.post_id = p.id
+		WHERE post_mentions.user_id = $1` + visibleClause("p") + notLimitedClause("p.author_id", 1) + notBlockedClause("p.author_id", 1) + after + `
+		ORDER BY p.created_at DESC, p.id DESC
+	` + page.limitClause()
+
+	posts, err := queryPosts(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	posts, next := page.nextPostCursor(posts)
+
+	err = hydratePosts(ctx, posts, userId)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	posts, err = applyFilters(ctx, posts, userId, FilterReplies)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return posts, next, http.StatusOK, nil
+}
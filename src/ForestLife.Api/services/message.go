@@ -0,0 +1,605 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const EventMessageCreated = "message.created"
+
+// Small group conversations include the creator
+const maxConversationMembers = 8
+
+const maxMessageLength = 2000
+
+type Conversation struct {
+	ID          string           `json:"id"`
+	Members     []ProfileSummary `json:"members"`
+	LastMessage *Message         `json:"last_message"`
+	UnreadCount int              `json:"unread_count"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+type NewConversation struct {
+	MemberIDs []string `json:"member_ids"`
+}
+
+type Message struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	Text           string    `json:"text"`
+	Media          string    `json:"media"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// canMessage checks that senderId may send direct messages to every user in
+// recipientIds
+func canMessage(ctx context.Context, senderId string, recipientIds []string) (int, error) {
+	var found int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE id = ANY($1::uuid[])`, pq.Array(recipientIds)).Scan(&found)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if found != len(recipientIds) {
+		return http.StatusNotFound, errors.New("userNotFound")
+	}
+
+	blocked, err := blockedBetween(ctx, senderId, recipientIds)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if blocked {
+		return http.StatusForbidden, errors.New("blocked")
+	}
+
+	// Recipients accepting DMs only from people they follow
+	policyQuery := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM users
+			INNER JOIN profiles ON users.profile_id = profiles.id
+			WHERE users.id = ANY($2::uuid[])
+				AND profiles.dm_policy = 'following'
+				AND NOT EXISTS (
+					SELECT 1
+					FROM follow_relationships
+					WHERE follower_id = users.id AND followee_id = $1
+				)
+		)
+	`
+
+	var restricted bool
+	err = db.QueryRowContext(ctx, policyQuery, senderId, pq.Array(recipientIds)).Scan(&restricted)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if restricted {
+		return http.StatusForbidden, errors.New("dmsRestricted")
+	}
+
+	return http.StatusOK, nil
+}
+
+// conversationMembers returns the members of a conversation, or notFound if
+// userId is not one of them
+func conversationMembers(ctx context.Context, conversationId string, userId string) ([]string, int, error) {
+	rows, err := db.QueryContext(ctx, `SELECT user_id FROM conversation_members WHERE conversation_id = $1`, conversationId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	var members []string
+	isMember := false
+
+	for rows.Next() {
+		var memberId string
+
+		if err := rows.Scan(&memberId); err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		if memberId == userId {
+			isMember = true
+		}
+
+		members = append(members, memberId)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if !isMember {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	return members, http.StatusOK, nil
+}
+
+func otherMembers(members []string, userId string) []string {
+	var others []string
+
+	for _, memberId := range members {
+		if memberId != userId {
+			others = append(others, memberId)
+		}
+	}
+
+	return others
+}
+
+func (c *Conversation) StartConversation(body NewConversation, sessionId string) (*Conversation, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	var recipients []string
+	seen := map[string]bool{userId: true}
+
+	for _, memberId := range body.MemberIDs {
+		if !seen[memberId] {
+			seen[memberId] = true
+			recipients = append(recipients, memberId)
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, http.StatusBadRequest, errors.New("membersRequired")
+	}
+
+	if len(recipients)+1 > maxConversationMembers {
+		return nil, http.StatusBadRequest, errors.New("tooManyMembers")
+	}
+
+	status, err := canMessage(ctx, userId, recipients)
+
+	if err != nil {
+		return nil, status, err
+	}
+
+	// One-to-one conversations are reused rather than duplicated
+	if len(recipients) == 1 {
+		existingQuery := `
+			SELECT conversation_id
+			FROM conversation_members
+			WHERE conversation_id IN (
+				SELECT conversation_id
+				FROM conversation_members
+				WHERE user_id = $2
+			)
+			GROUP BY conversation_id
+			HAVING COUNT(*) = 2 AND bool_and(user_id = ANY($1::uuid[]))
+			LIMIT 1
+		`
+
+		var existingId string
+		err := db.QueryRowContext(ctx, existingQuery, pq.Array([]string{userId, recipients[0]}), userId).Scan(&existingId)
+
+		if err == nil {
+			return c.getConversation(ctx, existingId, userId)
+		}
+
+		if err != sql.ErrNoRows {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	var conversationId string
+	err = tx.QueryRowContext(ctx, `INSERT INTO conversations (created_by) VALUES ($1) RETURNING id`, userId).Scan(&conversationId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	memberQuery := `
+		INSERT INTO conversation_members (conversation_id, user_id)
+		SELECT $1, unnest($2::uuid[])
+	`
+
+	_, err = tx.ExecContext(ctx, memberQuery, conversationId, pq.Array(append(recipients, userId)))
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return c.getConversation(ctx, conversationId, userId)
+}
+
+func (c *Conversation) getConversation(ctx context.Context, conversationId string, userId string) (*Conversation, int, error) {
+	conversations, err := queryConversations(ctx, ` AND c.id = $2`, "", userId, conversationId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if len(conversations) == 0 {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	return conversations[0], http.StatusOK, nil
+}
+
+// queryConversations loads the conversations of the user given as the first
+// argument, with their last message, unread count and members
+func queryConversations(ctx context.Context, filters string, limit string, args ...interface{}) ([]*Conversation, error) {
+	query := `
+		SELECT c.id, c.created_at, c.updated_at,
+			(
+				SELECT COUNT(*)
+				FROM messages
+				WHERE messages.conversation_id = c.id
+					AND messages.sender_id <> $1
+					AND messages.created_at > COALESCE(me.last_read_at, '-infinity')
+			),
+			last.id, last.sender_id, last.text, last.media, last.created_at
+		FROM conversations c
+		INNER JOIN conversation_members me ON me.conversation_id = c.id AND me.user_id = $1
+		LEFT JOIN LATERAL (
+			SELECT id, sender_id, text, media, created_at
+			FROM messages
+			WHERE messages.conversation_id = c.id
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		) last ON TRUE
+		WHERE TRUE` + filters + `
+		ORDER BY c.updated_at DESC, c.id DESC
+	` + limit
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	conversations := []*Conversation{}
+
+	for rows.Next() {
+		var conversation Conversation
+		var lastId, lastSender, lastText, lastMedia sql.NullString
+		var lastCreatedAt sql.NullTime
+
+		err := rows.Scan(
+			&conversation.ID,
+			&conversation.CreatedAt,
+			&conversation.UpdatedAt,
+			&conversation.UnreadCount,
+			&lastId,
+			&lastSender,
+			&lastText,
+			&lastMedia,
+			&lastCreatedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if lastId.Valid {
+			conversation.LastMessage = &Message{
+				ID:             lastId.String,
+				ConversationID: conversation.ID,
+				SenderID:       lastSender.String,
+				Text:           lastText.String,
+				Media:          lastMedia.String,
+				CreatedAt:      lastCreatedAt.Time,
+			}
+		}
+
+		conversations = append(conversations, &conversation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return conversations, loadConversationMembers(ctx, conversations)
+}
+
+func loadConversationMembers(ctx context.Context, conversations []*Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	byId := make(map[string]*Conversation, len(conversations))
+	ids := make([]string, 0, len(conversations))
+
+	for _, conversation := range conversations {
+		conversation.Members = []ProfileSummary{}
+		byId[conversation.ID] = conversation
+		ids = append(ids, conversation.ID)
+	}
+
+	query := `
+		SELECT conversation_members.conversation_id, users.id, profiles.username, COALESCE(profiles.nickname, '')
+		FROM conversation_members
+		INNER JOIN users ON users.id = conversation_members.user_id
+		INNER JOIN profiles ON users.profile_id = profiles.id
+		WHERE conversation_members.conversation_id = ANY($1::uuid[])
+		ORDER BY conversation_members.joined_at
+	`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationId string
+		var member ProfileSummary
+
+		err := rows.Scan(&conversationId, &member.UserID, &member.Username, &member.Nickname)
+
+		if err != nil {
+			return err
+		}
+
+		byId[conversationId].Members = append(byId[conversationId].Members, member)
+	}
+
+	return rows.Err()
+}
+
+func (c *Conversation) GetConversations(page Page, sessionId string) ([]*Conversation, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, "", http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	args := []interface{}{userId}
+	after, args, err := page.keyset(args, "c.updated_at", "c.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	conversations, err := queryConversations(ctx, after, page.limitClause(), args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	next := ""
+	if page.hasMore(len(conversations)) {
+		conversations = conversations[:page.size()]
+		last := conversations[len(conversations)-1]
+		next = timeCursor(last.UpdatedAt, last.ID)
+	}
+
+	return conversations, next, http.StatusOK, nil
+}
+
+func (c *Conversation) GetMessages(conversationId string, page Page, sessionId string) ([]*Message, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, "", http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	_, status, err := conversationMembers(ctx, conversationId, userId)
+
+	if err != nil {
+		return nil, "", status, err
+	}
+
+	args := []interface{}{conversationId}
+	after, args, err := page.keyset(args, "m.created_at", "m.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT m.id, m.conversation_id, m.sender_id, m.text, m.media, m.created_at
+		FROM messages m
+		WHERE m.conversation_id = $1` + after + `
+		ORDER BY m.created_at DESC, m.id DESC
+	` + page.limitClause()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	messages := []*Message{}
+
+	for rows.Next() {
+		var message Message
+		err := rows.Scan(
+			&message.ID,
+			&message.ConversationID,
+			&message.SenderID,
+			&message.Text,
+			&message.Media,
+			&message.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		messages = append(messages, &message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	next := ""
+	if page.hasMore(len(messages)) {
+		messages = messages[:page.size()]
+		last := messages[len(messages)-1]
+		next = timeCursor(last.CreatedAt, last.ID)
+	}
+
+	return messages, next, http.StatusOK, nil
+}
+
+func (c *Conversation) SendMessage(conversationId string, body Message, sessionId string) (*Message, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	body.Text = strings.TrimSpace(body.Text)
+
+	if body.Text == "" && body.Media == "" {
+		return nil, http.StatusBadRequest, errors.New("messageEmpty")
+	}
+
+	if utf8.RuneCountInString(body.Text) > maxMessageLength {
+		return nil, http.StatusBadRequest, errors.New("messageTooLong")
+	}
+
+	members, status, err := conversationMembers(ctx, conversationId, userId)
+
+	if err != nil {
+		return nil, status, err
+	}
+
+	// Blocks and DM preferences may have changed since the conversation started
+	status, err = canMessage(ctx, userId, otherMembers(members, userId))
+
+	if err != nil {
+		return nil, status, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO messages (conversation_id, sender_id, text, media, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, conversation_id, sender_id, text, media, created_at
+	`
+
+	var message Message
+	err = tx.QueryRowContext(ctx, query, conversationId, userId, body.Text, body.Media, time.Now()).Scan(
+		&message.ID,
+		&message.ConversationID,
+		&message.SenderID,
+		&message.Text,
+		&message.Media,
+		&message.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE conversations SET updated_at = $1 WHERE id = $2`, message.CreatedAt, conversationId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	// Sending a message means the sender has seen everything before it
+	readQuery := `
+		UPDATE conversation_members
+		SET last_read_at = $1
+		WHERE conversation_id = $2 AND user_id = $3
+	`
+
+	_, err = tx.ExecContext(ctx, readQuery, message.CreatedAt, conversationId, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	publish(ctx, EventMessageCreated, message, members)
+
+	return &message, http.StatusOK, nil
+}
+
+func (c *Conversation) MarkRead(conversationId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		UPDATE conversation_members
+		SET last_read_at = $1
+		WHERE conversation_id = $2 AND user_id = $3
+	`
+
+	result, err := db.ExecContext(ctx, query, time.Now(), conversationId, userId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	return http.StatusOK, nil
+}
@@ -0,0 +1,526 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	NotificationFollow    = "follow"
+	NotificationLike      = "like"
+	NotificationReaction  = "reaction"
+	NotificationMention   = "mention"
+	NotificationReply     = "reply"
+	NotificationPollEnded = "poll_ended"
+	NotificationWarning   = "warning"
+)
+
+var notificationTypes = []string{
+	NotificationFollow,
+	NotificationLike,
+	NotificationReaction,
+	NotificationMention,
+	NotificationReply,
+	NotificationPollEnded,
+	NotificationWarning,
+}
+
+// Notifications about the user's own account or posts, where they are also
+// the actor
+var selfNotifications = map[string]bool{
+	NotificationPollEnded: true,
+	NotificationWarning:   true,
+}
+
+// Repeating an action within this window, such as follow, unfollow and
+// follow again, does not notify the same user twice
+const notificationDedupeWindow = 24 * time.Hour
+
+// Events of the same type on the same target are grouped together when they
+// fall in the same window. Windows are fixed so a group keeps its key as it
+// grows, which keeps paging through groups stable.
+const notificationGroupWindow = 6 * time.Hour
+
+// How many of the most recent actors are returned with each group
+const notificationGroupActors = 3
+
+type Notification struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Actor     ProfileSummary `json:"actor"`
+	PostID    *string        `json:"post_id"`
+	ReadAt    *time.Time     `json:"read_at"`
+	CreatedAt time.Time      `json:"created_at"`
+	Filtered  []FilterResult `json:"filtered,omitempty"`
+}
+
+type NotificationGroup struct {
+	Key        string           `json:"key"`
+	Type       string           `json:"type"`
+	PostID     *string          `json:"post_id"`
+	Count      int              `json:"count"`
+	ActorCount int              `json:"actor_count"`
+	Actors     []ProfileSummary `json:"actors"`
+	Read       bool             `json:"read"`
+	LatestAt   time.Time        `json:"latest_at"`
+	Filtered   []FilterResult   `json:"filtered,omitempty"`
+}
+
+func isNotificationType(kind string) bool {
+	for _, t := range notificationTypes {
+		if t == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notify records that actorId did something that userId should hear about.
+// postId is empty for events that are not about a post, like follows.
+func notify(ctx context.Context, userId string, actorId string, kind string, postId string) error {
+	if userId == actorId && !selfNotifications[kind] {
+		return nil
+	}
+
+	// Blocked users can't reach each other, through notifications either
+	blocked, err := blockedBetween(ctx, userId, []string{actorId})
+
+	if err != nil || blocked {
+		return err
+	}
+
+	var post sql.NullString
+	if postId != "" {
+		post = sql.NullString{String: postId, Valid: true}
+	}
+
+	query := `
+		INSERT INTO notifications (user_id, actor_id, type, post_id)
+		SELECT $1, $2, $3, $4
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM notifications
+			WHERE user_id = $1
+				AND actor_id = $2
+				AND type = $3
+				AND post_id IS NOT DISTINCT FROM $4::uuid
+				AND created_at > $5
+		)
+		RETURNING id, type, post_id, created_at
+	`
+
+	var notification Notification
+	err = db.QueryRowContext(ctx, query, userId, actorId, kind, post, time.Now().Add(-notificationDedupeWindow)).Scan(
+		&notification.ID,
+		&notification.Type,
+		&notification.PostID,
+		&notification.CreatedAt,
+	)
+
+	// Nothing was inserted because a matching notification is recent enough
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	actors, err := loadProfileSummaries(ctx, []string{actorId})
+
+	if err != nil {
+		return err
+	}
+
+	notification.Actor = actors[actorId]
+
+	return publish(ctx, EventNotification, notification, []string{userId})
+}
+
+// notifyPostAuthor notifies the author of postId about something actorId did
+// to the post
+func notifyPostAuthor(ctx context.Context, postId string, actorId string, kind string) error {
+	var authorId string
+
+	// Nobody hears about activity on posts others can't see
+	query := `
+		SELECT p.author_id
+		FROM posts p
+		WHERE p.id = $1` + visibleClause("p") + `
+	`
+
+	err := db.QueryRowContext(ctx, query, postId).Scan(&authorId)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return notify(ctx, authorId, actorId, kind, postId)
+}
+
+func (n *Notification) GetNotifications(page Page, types []string, unreadOnly bool, sessionId string) ([]*Notification, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, "", http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	args := []interface{}{userId}
+	filters := ""
+
+	if len(types) > 0 {
+		for _, kind := range types {
+			if !isNotificationType(kind) {
+				return nil, "", http.StatusBadRequest, errors.New("invalidNotificationType")
+			}
+		}
+
+		args = append(args, pq.Array(types))
+		filters += fmt.Sprintf(" AND n.type = ANY($%d::text[])", len(args))
+	}
+
+	if unreadOnly {
+		filters += " AND n.read_at IS NULL"
+	}
+
+	after, args, err := page.keyset(args, "n.created_at", "n.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT n.id, n.type, n.actor_id, profiles.username, COALESCE(profiles.nickname, ''),
+			n.post_id, n.read_at, n.created_at
+		FROM notifications n
+		INNER JOIN users ON users.id = n.actor_id
+		INNER JOIN profiles ON users.profile_id = profiles.id
+		WHERE n.user_id = $1` + filters + notBlockedClause("n.actor_id", 1) + after + `
+		ORDER BY n.created_at DESC, n.id DESC
+	` + page.limitClause()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	notifications := []*Notification{}
+
+	for rows.Next() {
+		var notification Notification
+		err := rows.Scan(
+			&notification.ID,
+			&notification.Type,
+			&notification.Actor.UserID,
+			&notification.Actor.Username,
+			&notification.Actor.Nickname,
+			&notification.PostID,
+			&notification.ReadAt,
+			&notification.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		notifications = append(notifications, &notification)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	next := ""
+	if page.hasMore(len(notifications)) {
+		notifications = notifications[:page.size()]
+		last := notifications[len(notifications)-1]
+		next = timeCursor(last.CreatedAt, last.ID)
+	}
+
+	var postIds []string
+	for _, notification := range notifications {
+		if notification.PostID != nil {
+			postIds = append(postIds, *notification.PostID)
+		}
+	}
+
+	filtered, hidden, err := filterNotificationPosts(ctx, userId, postIds)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	kept := notifications[:0]
+
+	for _, notification := range notifications {
+		if notification.PostID != nil {
+			if hidden[*notification.PostID] {
+				continue
+			}
+
+			notification.Filtered = filtered[*notification.PostID]
+		}
+
+		kept = append(kept, notification)
+	}
+
+	return kept, next, http.StatusOK, nil
+}
+
+func (n *Notification) GetUnreadCount(sessionId string) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return 0, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		SELECT COUNT(*)
+		FROM notifications n
+		WHERE n.user_id = $1 AND n.read_at IS NULL` + notBlockedClause("n.actor_id", 1) + `
+	`
+
+	var count int
+	err = db.QueryRowContext(ctx, query, userId).Scan(&count)
+
+	if err != nil {
+		return 0, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return count, http.StatusOK, nil
+}
+
+func (n *Notification) MarkRead(id string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, $1)
+		WHERE id = $2 AND user_id = $3
+	`
+
+	result, err := db.ExecContext(ctx, query, time.Now(), id, userId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	return http.StatusOK, nil
+}
+
+func (n *Notification) MarkAllRead(sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		UPDATE notifications
+		SET read_at = $1
+		WHERE user_id = $2 AND read_at IS NULL
+	`
+
+	_, err = db.ExecContext(ctx, query, time.Now(), userId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return http.StatusOK, nil
+}
+
+func (n *Notification) GetNotificationGroups(page Page, types []string, sessionId string) ([]*NotificationGroup, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, "", http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	args := []interface{}{userId, notificationGroupWindow.Seconds()}
+	filters := ""
+
+	if len(types) > 0 {
+		for _, kind := range types {
+			if !isNotificationType(kind) {
+				return nil, "", http.StatusBadRequest, errors.New("invalidNotificationType")
+			}
+		}
+
+		args = append(args, pq.Array(types))
+		filters += fmt.Sprintf(" AND n.type = ANY($%d::text[])", len(args))
+	}
+
+	after, args, err := page.keyset(args, "latest_at", "group_key")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	// Actors are listed newest first; a few extra are kept so that repeat
+	// actors can be collapsed while still returning enough distinct ones
+	query := `
+		SELECT group_key, type, post_id, count, actor_count, read, latest_at, actor_ids
+		FROM (
+			SELECT
+				n.type || ':' || COALESCE(n.post_id::text, '') || ':' ||
+					floor(extract(epoch FROM n.created_at) / $2)::bigint AS group_key,
+				n.type,
+				n.post_id,
+				COUNT(*) AS count,
+				COUNT(DISTINCT n.actor_id) AS actor_count,
+				bool_and(n.read_at IS NOT NULL) AS read,
+				MAX(n.created_at) AS latest_at,
+				array_to_string((array_agg(n.actor_id ORDER BY n.created_at DESC))[1:10], ',') AS actor_ids
+			FROM notifications n
+			WHERE n.user_id = $1` + filters + notBlockedClause("n.actor_id", 1) + `
+			GROUP BY group_key, n.type, n.post_id
+		) groups
+		WHERE TRUE` + after + `
+		ORDER BY latest_at DESC, group_key DESC
+	` + page.limitClause()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	groups := []*NotificationGroup{}
+	groupActors := map[*NotificationGroup][]string{}
+	var actorIds []string
+
+	for rows.Next() {
+		var group NotificationGroup
+		var actors string
+
+		err := rows.Scan(
+			&group.Key,
+			&group.Type,
+			&group.PostID,
+			&group.Count,
+			&group.ActorCount,
+			&group.Read,
+			&group.LatestAt,
+			&actors,
+		)
+
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		var recent []string
+		seen := map[string]bool{}
+
+		for _, actorId := range strings.Split(actors, ",") {
+			if actorId == "" || seen[actorId] || len(recent) == notificationGroupActors {
+				continue
+			}
+
+			seen[actorId] = true
+			recent = append(recent, actorId)
+		}
+
+		groupActors[&group] = recent
+		actorIds = append(actorIds, recent...)
+		groups = append(groups, &group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	next := ""
+	if page.hasMore(len(groups)) {
+		groups = groups[:page.size()]
+		last := groups[len(groups)-1]
+		next = timeCursor(last.LatestAt, last.Key)
+	}
+
+	var postIds []string
+	for _, group := range groups {
+		if group.PostID != nil {
+			postIds = append(postIds, *group.PostID)
+		}
+	}
+
+	filtered, hidden, err := filterNotificationPosts(ctx, userId, postIds)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	kept := groups[:0]
+
+	for _, group := range groups {
+		if group.PostID != nil {
+			if hidden[*group.PostID] {
+				continue
+			}
+
+			group.Filtered = filtered[*group.PostID]
+		}
+
+		kept = append(kept, group)
+	}
+
+	groups = kept
+
+	profiles, err := loadProfileSummaries(ctx, actorIds)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	for _, group := range groups {
+		group.Actors = []ProfileSummary{}
+
+		for _, actorId := range groupActors[group] {
+			if profile, ok := profiles[actorId]; ok {
+				group.Actors = append(group.Actors, profile)
+			}
+		}
+	}
+
+	return groups, next, http.StatusOK, nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPageSize = 20
+const maxPageSize = 100
+
+// Page describes a keyset page: at most Limit items after the position
+// encoded in an opaque cursor returned by a previous page
+type Page struct {
+	Limit int
+	key   string
+	id    string
+}
+
+func NewPage(limit int, cursor string) (Page, error) {
+	page := Page{Limit: limit}
+
+	if cursor == "" {
+		return page, nil
+	}
+
+	key, id, err := decodeCursor(cursor)
+
+	if err != nil {
+		return page, err
+	}
+
+	page.key = key
+	page.id = id
+
+	return page, nil
+}
+
+func (pg Page) size() int {
+	if pg.Limit <= 0 {
+		return defaultPageSize
+	}
+
+	if pg.Limit > maxPageSize {
+		return maxPageSize
+	}
+
+	return pg.Limit
+}
+
+func encodeCursor(key string, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key + "|" + id))
+}
+
+func decodeCursor(cursor string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+
+	if err != nil {
+		return "", "", errors.New("invalidCursor")
+	}
+
+	key, id, found := strings.Cut(string(raw), "|")
+
+	if !found || key == "" || id == "" {
+		return "", "", errors.New("invalidCursor")
+	}
+
+	return key, id, nil
+}
+
+func timeCursor(t time.Time, id string) string {
+	return encodeCursor(t.UTC().Format(time.RFC3339Nano), id)
+}
+
+// keyset appends a condition selecting rows that sort after the cursor when
+// ordered by timeCol and idCol descending
+func (pg Page) keyset(args []interface{}, timeCol string, idCol string) (string, []interface{}, error) {
+	if pg.key == "" {
+		return "", args, nil
+	}
+
+	after, err := time.Parse(time.RFC3339Nano, pg.key)
+
+	if err != nil {
+		return "", args, errors.New("invalidCursor")
+	}
+
+	return pg.after(args, timeCol, idCol, after)
+}
+
+// rankKeyset is keyset for rows ordered by a numeric score, such as search
+// relevance, instead of time
+func (pg Page) rankKeyset(args []interface{}, rankCol string, idCol string) (string, []interface{}, error) {
+	if pg.key == "" {
+		return "", args, nil
+	}
+
+	after, err := strconv.ParseFloat(pg.key, 64)
+
+	if err != nil {
+		return "", args, errors.New("invalidCursor")
+	}
+
+	return pg.after(args, rankCol, idCol, after)
+}
+
+func (pg Page) after(args []interface{}, keyCol string, idCol string, key interface{}) (string, []interface{}, error) {
+	args = append(args, key, pg.id)
+	clause := fmt.Sprintf(" AND (%s, %s) < ($%d, $%d)", keyCol, idCol, len(args)-1, len(args))
+
+	return clause, args, nil
+}
+
+func rankCursor(rank float64, id string) string {
+	return encodeCursor(strconv.FormatFloat(rank, 'g', -1, 64), id)
+}
+
+// limitClause fetches one extra row so callers can tell whether another
+// page follows
+func (pg Page) limitClause() string {
+	return fmt.Sprintf(" LIMIT %d", pg.size()+1)
+}
+
+// hasMore reports whether a result of n rows fetched with limitClause has a
+// following page
+func (pg Page) hasMore(n int) bool {
+	return n > pg.size()
+}
+
+// nextPostCursor trims the extra row fetched by limitClause and returns the
+// cursor for the following page, if any
+func (pg Page) nextPostCursor(posts []*Post) ([]*Post, string) {
+	if !pg.hasMore(len(posts)) {
+		return posts, ""
+	}
+
+	posts = posts[:pg.size()]
+	last := posts[len(posts)-1]
+
+	return posts, timeCursor(last.CreatedAt, last.ID)
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// How many posts a user can pin to their profile
+var maxPinnedPosts = 3
+
+// SetMaxPinnedPosts changes how many posts each user can pin
+func SetMaxPinnedPosts(max int) {
+	if max > 0 {
+		maxPinnedPosts = max
+	}
+}
+
+type Pin struct {
+	PostIDs []string `json:"post_ids"`
+}
+
+// loadPins flags the posts their authors have pinned
+func loadPins(ctx context.Context, posts []*Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byId := make(map[string]*Post, len(posts))
+	ids := make([]string, 0, len(posts))
+
+	for _, p := range posts {
+		byId[p.ID] = p
+		ids = append(ids, p.ID)
+	}
+
+	query := `
+		SELECT pinned_posts.post_id
+		FROM pinned_posts
+		INNER JOIN posts ON posts.id = pinned_posts.post_id
+		WHERE pinned_posts.post_id = ANY($1::uuid[])
+			AND pinned_posts.user_id = posts.author_id
+	`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId string
+
+		if err := rows.Scan(&postId); err != nil {
+			return err
+		}
+
+		byId[postId].Pinned = true
+	}
+
+	return rows.Err()
+}
+
+// PinPost pins one of the caller's posts above the others on their profile
+func (p *Pin) PinPost(postId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	// Serialize pins by the same user so the limit can't be raced past
+	_, err = tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	var owned bool
+	ownedQuery := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM posts p
+			WHERE p.id = $1 AND p.author_id = $2` + visibleClause("p") + `
+		)
+	`
+
+	err = tx.QueryRowContext(ctx, ownedQuery, postId, userId).Scan(&owned)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if !owned {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	var pinned int
+	var alreadyPinned bool
+	countQuery := `
+		SELECT COUNT(*), COALESCE(bool_or(post_id = $2), FALSE)
+		FROM pinned_posts
+		WHERE user_id = $1
+	`
+
+	err = tx.QueryRowContext(ctx, countQuery, userId, postId).Scan(&pinned, &alreadyPinned)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if alreadyPinned {
+		return http.StatusOK, nil
+	}
+
+	if pinned >= maxPinnedPosts {
+		return http.StatusBadRequest, errors.New("tooManyPinnedPosts")
+	}
+
+	// New pins go above the existing ones
+	query := `
+		INSERT INTO pinned_posts (user_id, post_id, position)
+		SELECT $1, $2, COALESCE(MIN(position), 0) - 1
+		FROM pinned_posts
+		WHERE user_id = $1
+	`
+
+	_, err = tx.ExecContext(ctx, query, userId, postId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToPinPost")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToPinPost")
+	}
+
+	return http.StatusOK, nil
+}
+
+func (p *Pin) UnpinPost(postId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		DELETE FROM pinned_posts
+		WHERE user_id = $1 AND post_id = $2
+	`
+
+	_, err = db.ExecContext(ctx, query, userId, postId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToUnpinPost")
+	}
+
+	return http.StatusOK, nil
+}
+
+// ReorderPins puts the caller's pinned posts in the order given, which must
+// list every pinned post exactly once
+func (p *Pin) ReorderPins(order Pin, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT post_id FROM pinned_posts WHERE user_id = $1 FOR UPDATE`, userId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	pinned := map[string]bool{}
+
+	for rows.Next() {
+		var postId string
+
+		if err := rows.Scan(&postId); err != nil {
+			rows.Close()
+			return http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		pinned[postId] = true
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if len(order.PostIDs) != len(pinned) {
+		return http.StatusBadRequest, errors.New("pinsMismatch")
+	}
+
+	seen := map[string]bool{}
+	for _, postId := range order.PostIDs {
+		if !pinned[postId] || seen[postId] {
+			return http.StatusBadRequest, errors.New("pinsMismatch")
+		}
+
+		seen[postId] = true
+	}
+
+	query := `
+		UPDATE pinned_posts
+		SET position = ordered.position
+		FROM unnest($2::uuid[]) WITH ORDINALITY AS ordered(post_id, position)
+		WHERE pinned_posts.user_id = $1 AND pinned_posts.post_id = ordered.post_id
+	`
+
+	_, err = tx.ExecContext(ctx, query, userId, pq.Array(order.PostIDs))
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToReorderPins")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToReorderPins")
+	}
+
+	return http.StatusOK, nil
+}
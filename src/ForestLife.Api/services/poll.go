@@ -0,0 +1,424 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const minPollOptions = 2
+const maxPollOptions = 6
+const maxPollOptionLength = 100
+
+// Polls run for at least this long and at most this long after their post
+// is published
+const minPollDuration = 5 * time.Minute
+const maxPollDuration = 30 * 24 * time.Hour
+
+// How often ended polls are looked for so their authors can be notified
+const pollEndedInterval = time.Minute
+
+// Whether voters can replace their vote while the poll is open
+var pollVoteChanges = false
+
+// SetPollVoteChanges allows or forbids changing a poll vote
+func SetPollVoteChanges(allowed bool) {
+	pollVoteChanges = allowed
+}
+
+// Poll is attached to a post. When creating a post only the option titles,
+// Multiple and ExpiresAt are read. Tallies are left out until the viewer has
+// voted or the poll has closed.
+type Poll struct {
+	ID          string       `json:"id"`
+	Options     []PollOption `json:"options"`
+	Multiple    bool         `json:"multiple"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	Expired     bool         `json:"expired"`
+	Voted       bool         `json:"voted"`
+	OwnVotes    []int        `json:"own_votes"`
+	VotesCount  *int         `json:"votes_count"`
+	VotersCount *int         `json:"voters_count"`
+}
+
+type PollOption struct {
+	Title      string `json:"title"`
+	VotesCount *int   `json:"votes_count"`
+}
+
+type PollVote struct {
+	Choices []int `json:"choices"`
+}
+
+// validatePoll checks a poll for a post that goes live at publishAt and
+// tidies up its option titles
+func validatePoll(poll *Poll, publishAt time.Time) error {
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		return errors.New("invalidPollOptions")
+	}
+
+	seen := map[string]bool{}
+
+	for i := range poll.Options {
+		title := strings.TrimSpace(poll.Options[i].Title)
+
+		if title == "" || utf8.RuneCountInString(title) > maxPollOptionLength || seen[title] {
+			return errors.New("invalidPollOptions")
+		}
+
+		seen[title] = true
+		poll.Options[i].Title = title
+	}
+
+	duration := poll.ExpiresAt.Sub(publishAt)
+
+	if duration < minPollDuration || duration > maxPollDuration {
+		return errors.New("invalidPollExpiry")
+	}
+
+	return nil
+}
+
+// createPoll stores a validated poll for postId
+func createPoll(ctx context.Context, tx *sql.Tx, postId string, poll *Poll) error {
+	query := `
+		INSERT INTO polls (post_id, multiple, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`
+
+	err := tx.QueryRowContext(ctx, query, postId, poll.Multiple, poll.ExpiresAt).Scan(&poll.ID)
+
+	if err != nil {
+		return err
+	}
+
+	titles := make([]string, len(poll.Options))
+	for i, option := range poll.Options {
+		titles[i] = option.Title
+	}
+
+	optionQuery := `
+		INSERT INTO poll_options (poll_id, position, title)
+		SELECT $1, options.position - 1, options.title
+		FROM unnest($2::text[]) WITH ORDINALITY AS options(title, position)
+	`
+
+	_, err = tx.ExecContext(ctx, optionQuery, poll.ID, pq.Array(titles))
+
+	return err
+}
+
+// loadPolls attaches polls to the posts that have one, with tallies when the
+// viewer is allowed to see them
+func loadPolls(ctx context.Context, posts []*Post, viewerId string) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byId := make(map[string]*Post, len(posts))
+	ids := make([]string, 0, len(posts))
+
+	for _, p := range posts {
+		p.Poll = nil
+		byId[p.ID] = p
+		ids = append(ids, p.ID)
+	}
+
+	var viewer sql.NullString
+	if viewerId != "" {
+		viewer = sql.NullString{String: viewerId, Valid: true}
+	}
+
+	query := `
+		SELECT polls.post_id, polls.id, polls.multiple, polls.expires_at,
+			poll_options.title,
+			(SELECT COUNT(*) FROM poll_votes WHERE poll_votes.option_id = poll_options.id),
+			(SELECT COUNT(DISTINCT user_id) FROM poll_votes WHERE poll_votes.poll_id = polls.id),
+			EXISTS (
+				SELECT 1
+				FROM poll_votes
+				WHERE poll_votes.option_id = poll_options.id AND poll_votes.user_id = $2
+			)
+		FROM polls
+		INNER JOIN poll_options ON poll_options.poll_id = polls.id
+		WHERE polls.post_id = ANY($1::uuid[])
+		ORDER BY polls.post_id, poll_options.position
+	`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids), viewer)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	counts := map[*Poll][]int{}
+	voters := map[*Poll]int{}
+
+	for rows.Next() {
+		var postId string
+		var poll Poll
+		var option PollOption
+		var votes, voterCount int
+		var mine bool
+
+		err := rows.Scan(
+			&postId,
+			&poll.ID,
+			&poll.Multiple,
+			&poll.ExpiresAt,
+			&option.Title,
+			&votes,
+			&voterCount,
+			&mine,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		post := byId[postId]
+
+		if post.Poll == nil {
+			poll.Options = []PollOption{}
+			poll.OwnVotes = []int{}
+			poll.Expired = !poll.ExpiresAt.After(time.Now())
+			post.Poll = &poll
+		}
+
+		current := post.Poll
+
+		if mine {
+			current.Voted = true
+			current.OwnVotes = append(current.OwnVotes, len(current.Options))
+		}
+
+		current.Options = append(current.Options, option)
+		counts[current] = append(counts[current], votes)
+		voters[current] = voterCount
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for poll, optionCounts := range counts {
+		if !poll.Voted && !poll.Expired {
+			continue
+		}
+
+		total := 0
+
+		for i := range poll.Options {
+			count := optionCounts[i]
+			poll.Options[i].VotesCount = &count
+			total += count
+		}
+
+		voterCount := voters[poll]
+		poll.VotesCount = &total
+		poll.VotersCount = &voterCount
+	}
+
+	return nil
+}
+
+// VotePoll records the caller's choices, given as option indexes, in the poll
+// attached to postId and returns the poll with its tallies
+func (p *Poll) VotePoll(postId string, vote PollVote, sessionId string) (*Poll, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	pollQuery := `
+		SELECT polls.id, polls.multiple, polls.expires_at, p.author_id
+		FROM polls
+		INNER JOIN posts p ON p.id = polls.post_id
+		WHERE polls.post_id = $1` + visibleClause("p")
+
+	var pollId, authorId string
+	var multiple bool
+	var expiresAt time.Time
+
+	err = tx.QueryRowContext(ctx, pollQuery, postId).Scan(&pollId, &multiple, &expiresAt, &authorId)
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if !expiresAt.After(time.Now()) {
+		return nil, http.StatusBadRequest, errors.New("pollClosed")
+	}
+
+	blocked, err := blockedBetween(ctx, userId, []string{authorId})
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if blocked {
+		return nil, http.StatusForbidden, errors.New("blocked")
+	}
+
+	if len(vote.Choices) == 0 || (!multiple && len(vote.Choices) > 1) {
+		return nil, http.StatusBadRequest, errors.New("invalidChoices")
+	}
+
+	choices := make([]int64, 0, len(vote.Choices))
+	seen := map[int]bool{}
+
+	for _, choice := range vote.Choices {
+		if choice < 0 || choice >= maxPollOptions || seen[choice] {
+			return nil, http.StatusBadRequest, errors.New("invalidChoices")
+		}
+
+		seen[choice] = true
+		choices = append(choices, int64(choice))
+	}
+
+	// Votes by the same user on the same poll are serialized so a single
+	// choice poll can't end up with two of their votes
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1 || $2))`, pollId, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	var voted bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM poll_votes WHERE poll_id = $1 AND user_id = $2)`, pollId, userId).Scan(&voted)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if voted && !pollVoteChanges {
+		return nil, http.StatusConflict, errors.New("alreadyVoted")
+	}
+
+	if voted {
+		_, err = tx.ExecContext(ctx, `DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2`, pollId, userId)
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("unableToVote")
+		}
+	}
+
+	voteQuery := `
+		INSERT INTO poll_votes (poll_id, option_id, user_id)
+		SELECT $1, id, $3
+		FROM poll_options
+		WHERE poll_id = $1 AND position = ANY($2::int[])
+	`
+
+	result, err := tx.ExecContext(ctx, voteQuery, pollId, pq.Array(choices), userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToVote")
+	}
+
+	// Every choice must match one of the poll's options
+	if affected, _ := result.RowsAffected(); affected != int64(len(choices)) {
+		return nil, http.StatusBadRequest, errors.New("invalidChoices")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToVote")
+	}
+
+	post := &Post{ID: postId}
+	err = loadPolls(ctx, []*Post{post}, userId)
+
+	if err != nil || post.Poll == nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return post.Poll, http.StatusOK, nil
+}
+
+// notifyEndedPolls tells authors that their polls have closed. Polls are
+// claimed with SKIP LOCKED and marked in the same statement, so each author
+// is notified once even when several replicas run this job. Every claimed
+// poll is marked, but only authors of posts others can see are notified, so
+// polls on drafts, held or deleted posts don't keep being claimed.
+func notifyEndedPolls(ctx context.Context) error {
+	query := `
+		UPDATE polls
+		SET ended_notified_at = NOW()
+		FROM posts p
+		WHERE polls.id IN (
+			SELECT id
+			FROM polls
+			WHERE expires_at <= NOW() AND ended_notified_at IS NULL
+			ORDER BY expires_at
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		)
+			AND polls.ended_notified_at IS NULL
+			AND p.id = polls.post_id
+		RETURNING p.id, p.author_id, EXISTS (
+			SELECT 1
+			FROM posts visible
+			WHERE visible.id = p.id` + visibleClause("visible") + `
+		)
+	`
+
+	rows, err := db.QueryContext(ctx, query)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	type ended struct{ postId, authorId string }
+	var polls []ended
+
+	for rows.Next() {
+		var poll ended
+		var visible bool
+
+		if err := rows.Scan(&poll.postId, &poll.authorId, &visible); err != nil {
+			return err
+		}
+
+		if visible {
+			polls = append(polls, poll)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, poll := range polls {
+		notify(ctx, poll.authorId, poll.authorId, NotificationPollEnded, poll.postId)
+	}
+
+	return nil
+}
@@ -2,39 +2,173 @@ package services
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 var auth Auth
 
+const (
+	PostPublished = "published"
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostHeld      = "held"
+)
+
+// How often due scheduled posts are published
+const scheduledPostsInterval = 15 * time.Second
+
+// How long deleted posts stay in the trash, restorable, before they are
+// purged along with everything that depends on them
+var trashRetention = 30 * 24 * time.Hour
+
+// How often posts past the trash retention are purged
+const purgeInterval = 10 * time.Minute
+
+// SetTrashRetention changes how long deleted posts can be restored
+func SetTrashRetention(retention time.Duration) {
+	if retention > 0 {
+		trashRetention = retention
+	}
+}
+
+const maxSpoilerTextLength = 200
+
+// How long after publishing a post can still be edited. Zero allows edits at
+// any time.
+var editWindow time.Duration
+
+// SetEditWindow limits edits to the given time after a post is published
+func SetEditWindow(window time.Duration) {
+	editWindow = window
+}
+
 type Post struct {
-	ID        string    `json:"id"`
+	ID              string          `json:"id"`
+	Text            string          `json:"text"`
+	Image           string          `json:"image"`
+	AuthorID        string          `json:"author_id"`
+	InReplyToID     *string         `json:"in_reply_to_id"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	Reactions       []ReactionCount `json:"reactions"`
+	MyReactions     []string        `json:"my_reactions"`
+	DisplayText     string          `json:"display_text"`
+	Entities        []Entity        `json:"entities"`
+	HTML            string          `json:"html"`
+	Bookmarked      bool            `json:"bookmarked"`
+	Status          string          `json:"status"`
+	PublishAt       *time.Time      `json:"publish_at"`
+	EditedAt        *time.Time      `json:"edited_at"`
+	EditCount       int             `json:"edit_count"`
+	DeletedAt       *time.Time      `json:"deleted_at,omitempty"`
+	Pinned          bool            `json:"pinned"`
+	Poll            *Poll           `json:"poll"`
+	SpoilerText     string          `json:"spoiler_text"`
+	Sensitive       bool            `json:"sensitive"`
+	SensitiveForced bool            `json:"sensitive_forced"`
+	MediaDisplay    string          `json:"media_display"`
+	Card            *LinkPreview    `json:"card"`
+	Filtered        []FilterResult  `json:"filtered,omitempty"`
+}
+
+// PostRevision is one version of a post's content
+type PostRevision struct {
 	Text      string    `json:"text"`
 	Image     string    `json:"image"`
-	AuthorID  string    `json:"author_id"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
 }
 
-func (p *Post) LikePost(postId string, sessionId string) error {
+// hydratePosts attaches data stored outside the posts table, such as
+// reactions and mentions, to posts before they are returned to a viewer
+func hydratePosts(ctx context.Context, posts []*Post, viewerId string) error {
+	err := loadReactions(ctx, posts, viewerId)
+
+	if err != nil {
+		return err
+	}
+
+	err = loadEntities(ctx, posts)
+
+	if err != nil {
+		return err
+	}
+
+	err = loadPins(ctx, posts)
+
+	if err != nil {
+		return err
+	}
+
+	err = loadPolls(ctx, posts, viewerId)
+
+	if err != nil {
+		return err
+	}
+
+	err = loadLinkPreviews(ctx, posts)
+
+	if err != nil {
+		return err
+	}
+
+	err = loadMediaDisplay(ctx, posts, viewerId)
+
+	if err != nil {
+		return err
+	}
+
+	return loadBookmarks(ctx, posts, viewerId)
+}
+
+// LikePost likes a post the caller can see. Liking a post twice is a no-op.
+func (p *Post) LikePost(postId string, sessionId string) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	userId, err := auth.GetUserId(ctx, sessionId)
 
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
 	query := `
-		INSERT INTO post_likes (post_id, user_id)
-		VALUES ($1, $2)
+		WITH target AS (
+			SELECT p.id
+			FROM posts p
+			WHERE p.id = $1` + visibleClause("p") + notLimitedClause("p.author_id", 2) + `
+		), liked AS (
+			INSERT INTO post_likes (post_id, user_id)
+			SELECT id, $2
+			FROM target
+			ON CONFLICT ON CONSTRAINT UQ_post_likes DO NOTHING
+			RETURNING post_id
+		)
+		SELECT EXISTS (SELECT 1 FROM target), EXISTS (SELECT 1 FROM liked)
 	`
 
-	_, err = db.ExecContext(ctx, query, postId, userId)
+	var found, liked bool
+	err = db.QueryRowContext(ctx, query, postId, userId).Scan(&found, &liked)
 
 	if err != nil {
-		return err
+		return http.StatusInternalServerError, errors.New("serverError")
 	}
 
-	return nil
+	if !found {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	// Notifications are best effort and never fail the like itself
+	if liked {
+		notifyPostAuthor(ctx, postId, userId, NotificationLike)
+	}
+
+	return http.StatusOK, nil
 }
 
 func (p *Post) UnlikePost(postId string, sessionId string) error {
@@ -57,93 +191,297 @@ func (p *Post) UnlikePost(postId string, sessionId string) error {
 	return nil
 }
 
-func (p *Post) GetPosts(authorId string) ([]*Post, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+const postColumns = `p.id, p.text, p.image, p.author_id, p.created_at, p.updated_at, p.status, p.publish_at, p.edited_at, p.edit_count, p.deleted_at, p.spoiler_text, p.sensitive, p.sensitive_forced, p.in_reply_to_id`
 
-	query := `
-		SELECT id, text, image, author_id, created_at, updated_at
-		FROM posts
-		WHERE author_id = $1
-	`
+const postReturning = `RETURNING id, text, image, author_id, created_at, updated_at, status, publish_at, edited_at, edit_count, deleted_at, spoiler_text, sensitive, sensitive_forced, in_reply_to_id`
+
+// visibleClause keeps only posts under alias that can be shown to other
+// users, leaving out drafts, posts scheduled for later, deleted posts and
+// posts by suspended accounts
+func visibleClause(alias string) string {
+	return fmt.Sprintf(" AND %[1]s.status = 'published' AND %[1]s.deleted_at IS NULL", alias) + notSuspendedClause(alias+".author_id")
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	rows, err := db.QueryContext(ctx, query, authorId)
+func scanPost(row rowScanner) (*Post, error) {
+	var post Post
+	err := row.Scan(
+		&post.ID,
+		&post.Text,
+		&post.Image,
+		&post.AuthorID,
+		&post.CreatedAt,
+		&post.UpdatedAt,
+		&post.Status,
+		&post.PublishAt,
+		&post.EditedAt,
+		&post.EditCount,
+		&post.DeletedAt,
+		&post.SpoilerText,
+		&post.Sensitive,
+		&post.SensitiveForced,
+		&post.InReplyToID,
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var posts []*Post
+	return &post, nil
+}
+
+func queryPosts(ctx context.Context, query string, args ...interface{}) ([]*Post, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	posts := []*Post{}
 
 	for rows.Next() {
-		var post Post
-		err := rows.Scan(
-			&post.ID,
-			&post.Text,
-			&post.Image,
-			&post.AuthorID,
-			&post.CreatedAt,
-			&post.UpdatedAt,
-		)
+		post, err := scanPost(rows)
 
 		if err != nil {
 			return nil, err
 		}
 
-		posts = append(posts, &post)
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+func (p *Post) GetPosts(authorId string, sessionId string) ([]*Post, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// Pinned posts come first, in the order the author arranged them
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		LEFT JOIN pinned_posts ON pinned_posts.post_id = p.id AND pinned_posts.user_id = p.author_id
+		WHERE p.author_id = $1` + visibleClause("p") + notLimitedClause("p.author_id", 2) + `
+		ORDER BY pinned_posts.position NULLS LAST, p.created_at DESC, p.id DESC
+	`
+
+	viewer := viewerId(ctx, sessionId)
+	posts, err := queryPosts(ctx, query, authorId, sql.NullString{String: viewer, Valid: viewer != ""})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = hydratePosts(ctx, posts, viewer)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return posts, nil
 }
 
-func (p *Post) GetPostById(id string) (*Post, error) {
+func (p *Post) GetPostById(id string, sessionId string) (*Post, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	// Authors can open their own drafts and scheduled posts
 	query := `
-		SELECT id, text, image, author_id, created_at, updated_at
-		FROM posts
-		WHERE id = $1
+		SELECT ` + postColumns + `
+		FROM posts p
+		WHERE p.id = $1
+			AND (p.status = 'published' OR p.author_id = $2)
+			AND p.deleted_at IS NULL` + notSuspendedClause("p.author_id") + notLimitedClause("p.author_id", 2) + `
 	`
 
-	row := db.QueryRowContext(ctx, query, id)
+	viewer := viewerId(ctx, sessionId)
+	post, err := scanPost(db.QueryRowContext(ctx, query, id, sql.NullString{String: viewer, Valid: viewer != ""}))
 
-	var post Post
-	err := row.Scan(
-		&post.ID,
-		&post.Text,
-		&post.Image,
-		&post.AuthorID,
-		&post.CreatedAt,
-		&post.UpdatedAt,
-	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = hydratePosts(ctx, []*Post{post}, viewer)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &post, nil
+	return post, nil
 }
 
-func (p *Post) CreatePost(post Post, sessionId string) (*Post, error) {
+// schedule resolves the status a post is saved with and when it goes out.
+// A publish_at without a status schedules the post.
+func schedule(status string, publishAt *time.Time) (string, *time.Time, error) {
+	if status == "" {
+		status = PostPublished
+
+		if publishAt != nil {
+			status = PostScheduled
+		}
+	}
+
+	switch status {
+	case PostPublished, PostDraft:
+		return status, nil, nil
+	case PostScheduled:
+		if publishAt == nil || !publishAt.After(time.Now()) {
+			return "", nil, errors.New("invalidPublishAt")
+		}
+
+		return status, publishAt, nil
+	}
+
+	return "", nil, errors.New("invalidStatus")
+}
+
+// validateSpoilerText tidies up a post's content warning
+func validateSpoilerText(spoilerText string) (string, error) {
+	spoilerText = strings.TrimSpace(spoilerText)
+
+	if utf8.RuneCountInString(spoilerText) > maxSpoilerTextLength {
+		return "", errors.New("spoilerTextTooLong")
+	}
+
+	return spoilerText, nil
+}
+
+// checkReplyTarget checks that userId can reply to the post with id. Posts
+// the user can't see, including those by users they blocked or who blocked
+// them, look the same as posts that don't exist.
+func checkReplyTarget(ctx context.Context, id string, userId string) (int, error) {
+	query := `
+		SELECT p.author_id
+		FROM posts p
+		WHERE p.id = $1` + visibleClause("p") + notLimitedClause("p.author_id", 2) + `
+	`
+
+	var authorId string
+	err := db.QueryRowContext(ctx, query, id, userId).Scan(&authorId)
+
+	if err == sql.ErrNoRows {
+		return http.StatusNotFound, errors.New("replyTargetNotFound")
+	}
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	blocked, err := blockedBetween(ctx, userId, []string{authorId})
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if blocked {
+		return http.StatusNotFound, errors.New("replyTargetNotFound")
+	}
+
+	return http.StatusOK, nil
+}
+
+// announcePost notifies the author of the post replied to and the users
+// mentioned in a post that has just been published, and sends it to its
+// author's audience
+func announcePost(ctx context.Context, post Post) {
+	// The author replied to hears about it once, as a reply
+	repliedTo := ""
+
+	if post.InReplyToID != nil {
+		err := db.QueryRowContext(ctx, `SELECT author_id FROM posts WHERE id = $1`, *post.InReplyToID).Scan(&repliedTo)
+
+		if err == nil {
+			notify(ctx, repliedTo, post.AuthorID, NotificationReply, post.ID)
+		}
+	}
+
+	mentioned, err := postMentionedUsers(ctx, post.ID)
+
+	if err == nil {
+		for _, mentionedId := range mentioned {
+			if mentionedId != repliedTo {
+				notify(ctx, mentionedId, post.AuthorID, NotificationMention, post.ID)
+			}
+		}
+	}
+
+	publishPost(ctx, EventPostCreated, post)
+}
+
+func (p *Post) CreatePost(post Post, sessionId string) (*Post, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	userId, err := auth.GetUserId(ctx, sessionId)
 
 	if err != nil {
-		return nil, errors.New("unauthorized")
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	status, publishAt, err := schedule(post.Status, post.PublishAt)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
 	}
 
-	post.AuthorID = userId
+	spoilerText, err := validateSpoilerText(post.SpoilerText)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	if post.Poll != nil {
+		// Polls on scheduled posts run from when the post goes live
+		opensAt := time.Now()
+		if publishAt != nil {
+			opensAt = *publishAt
+		}
+
+		if err := validatePoll(post.Poll, opensAt); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+
+	if post.InReplyToID != nil {
+		if code, err := checkReplyTarget(ctx, *post.InReplyToID, userId); err != nil {
+			return nil, code, err
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	// Drafts are screened when they go out instead
+	var heldReason sql.NullString
+
+	if status != PostDraft {
+		var code int
+		heldReason, code, err = screenPost(ctx, tx, userId, post.Text)
+
+		if err != nil {
+			return nil, code, err
+		}
+
+		if heldReason.Valid {
+			status = PostHeld
+		}
+	}
 
 	query := `
-		INSERT INTO posts (text, image, author_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
-		returning *
-	`
+		INSERT INTO posts (text, image, author_id, created_at, updated_at, status, publish_at, spoiler_text, sensitive, content_hash, held_reason, in_reply_to_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	` + postReturning
 
-	_, err = db.ExecContext(
+	created, err := scanPost(tx.QueryRowContext(
 		ctx,
 		query,
 		post.Text,
@@ -151,49 +489,427 @@ func (p *Post) CreatePost(post Post, sessionId string) (*Post, error) {
 		userId,
 		time.Now(),
 		time.Now(),
-	)
+		status,
+		publishAt,
+		spoilerText,
+		post.Sensitive,
+		contentHash(post.Text),
+		heldReason,
+		post.InReplyToID,
+	))
 
 	if err != nil {
-		return nil, err
+		return nil, http.StatusInternalServerError, errors.New("unableToCreatePost")
 	}
 
-	return &post, nil
+	err = syncTags(ctx, tx, created.ID, created.Text)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToCreatePost")
+	}
+
+	// Mentions are linked for drafts too, but only notified once published
+	_, err = syncMentions(ctx, tx, created.ID, created.Text)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToCreatePost")
+	}
+
+	err = syncLink(ctx, tx, created.ID, created.Text)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToCreatePost")
+	}
+
+	if post.Poll != nil {
+		err = createPoll(ctx, tx, created.ID, post.Poll)
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("unableToCreatePost")
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToCreatePost")
+	}
+
+	if created.Status == PostPublished {
+		announcePost(ctx, *created)
+	}
+
+	err = hydratePosts(ctx, []*Post{created}, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return created, http.StatusOK, nil
 }
 
-func (p *Post) UpdatePost(id string, body Post, sessionId string) (*Post, error) {
+// UpdatePost edits a post. Drafts and scheduled posts can also be
+// rescheduled, turned back into drafts or published right away through
+// status and publish_at; published posts stay published unless an edit
+// is held for review.
+func (p *Post) UpdatePost(id string, body Post, sessionId string) (*Post, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	userId, err := auth.GetUserId(ctx, sessionId)
 
 	if err != nil {
-		return nil, errors.New("unauthorized")
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	spoilerText, err := validateSpoilerText(body.SpoilerText)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	// Lock the post so the scheduled publisher can't publish it mid-edit
+	currentQuery := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		WHERE p.id = $1 AND p.author_id = $2 AND p.deleted_at IS NULL
+		FOR UPDATE
+	`
+
+	existing, err := scanPost(tx.QueryRowContext(ctx, currentQuery, id, userId))
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	current := existing.Status
+	status, publishAt := current, existing.PublishAt
+
+	if current == PostPublished {
+		if (body.Status != "" && body.Status != PostPublished) || body.PublishAt != nil {
+			return nil, http.StatusBadRequest, errors.New("alreadyPublished")
+		}
+
+		if editWindow > 0 && time.Since(existing.CreatedAt) > editWindow {
+			return nil, http.StatusForbidden, errors.New("editWindowClosed")
+		}
+	} else if current == PostHeld {
+		// Only a moderator can let a held post out
+		if body.Status != "" || body.PublishAt != nil {
+			return nil, http.StatusForbidden, errors.New("postHeld")
+		}
+	} else if body.Status != "" || body.PublishAt != nil {
+		requested := body.PublishAt
+		if requested == nil && body.Status == PostScheduled {
+			requested = existing.PublishAt
+		}
+
+		status, publishAt, err = schedule(body.Status, requested)
+
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+
+	// A post published now takes its place in feeds as of now
+	publishing := current != PostPublished && status == PostPublished
+
+	// A draft going out is screened like a new post. New text for a post
+	// that is out or scheduled is screened too, so links or copied text
+	// can't be edited in after the post passed.
+	var heldReason sql.NullString
+
+	if current == PostDraft && status != PostDraft {
+		var code int
+		heldReason, code, err = screenPost(ctx, tx, userId, body.Text)
+
+		if err != nil {
+			return nil, code, err
+		}
+	} else if current != PostHeld && status != PostDraft && body.Text != existing.Text {
+		heldReason, err = screenEdit(ctx, tx, userId, body.Text)
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+	}
+
+	if heldReason.Valid {
+		status = PostHeld
+		publishing = false
+	}
+
+	// Only changes readers could have seen are kept as revisions; drafts
+	// and scheduled posts are edited freely
+	edited := current == PostPublished && (body.Text != existing.Text || body.Image != existing.Image)
+
+	if edited {
+		liveSince := existing.CreatedAt
+		if existing.EditedAt != nil {
+			liveSince = *existing.EditedAt
+		}
+
+		revisionQuery := `
+			INSERT INTO post_revisions (post_id, text, image, created_at)
+			VALUES ($1, $2, $3, $4)
+		`
+
+		_, err = tx.ExecContext(ctx, revisionQuery, existing.ID, existing.Text, existing.Image, liveSince)
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("unableToUpdatePost")
+		}
 	}
 
 	query := `
 		UPDATE posts
-		SET text = $1, image = $2, updated_at = $3
-		WHERE id = $4 AND author_id = $5
-		returning *
-	`
+		SET text = $1, image = $2, updated_at = $3, status = $4, publish_at = $5,
+			created_at = CASE WHEN $6 THEN $3 ELSE created_at END,
+			edited_at = CASE WHEN $8 THEN $3 ELSE edited_at END,
+			edit_count = edit_count + CASE WHEN $8 THEN 1 ELSE 0 END,
+			spoiler_text = $9,
+			sensitive = $10 OR sensitive_forced,
+			content_hash = $11,
+			held_reason = COALESCE($12, held_reason)
+		WHERE id = $7
+	` + postReturning
 
-	_, err = db.ExecContext(
+	updated, err := scanPost(tx.QueryRowContext(
 		ctx,
 		query,
 		body.Text,
 		body.Image,
 		time.Now(),
+		status,
+		publishAt,
+		publishing,
 		id,
-		userId,
-	)
+		edited,
+		spoilerText,
+		body.Sensitive,
+		contentHash(body.Text),
+		heldReason,
+	))
 
 	if err != nil {
-		return nil, err
+		return nil, http.StatusInternalServerError, errors.New("unableToUpdatePost")
+	}
+
+	// Keep the post's tag and mention links in sync with the edited text
+	err = syncTags(ctx, tx, updated.ID, updated.Text)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToUpdatePost")
+	}
+
+	mentioned, err := syncMentions(ctx, tx, updated.ID, updated.Text)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToUpdatePost")
+	}
+
+	err = syncLink(ctx, tx, updated.ID, updated.Text)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToUpdatePost")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToUpdatePost")
+	}
+
+	if publishing {
+		announcePost(ctx, *updated)
+	} else if current == PostPublished && updated.Status == PostHeld {
+		// Timelines drop the post until a moderator approves it
+		publishPost(ctx, EventPostDeleted, *updated)
+	} else if updated.Status == PostPublished {
+		for _, mentionedId := range mentioned {
+			notify(ctx, mentionedId, userId, NotificationMention, updated.ID)
+		}
+
+		publishPost(ctx, EventPostUpdated, *updated)
+	}
+
+	err = hydratePosts(ctx, []*Post{updated}, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return updated, http.StatusOK, nil
+}
+
+// GetPostHistory returns every version of a published post, newest first,
+// starting with the current one
+func (p *Post) GetPostHistory(id string, sessionId string) ([]*PostRevision, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	current, err := p.GetPostById(id, sessionId)
+
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	liveSince := current.CreatedAt
+	if current.EditedAt != nil {
+		liveSince = *current.EditedAt
+	}
+
+	history := []*PostRevision{{Text: current.Text, Image: current.Image, CreatedAt: liveSince}}
+
+	query := `
+		SELECT text, image, created_at
+		FROM post_revisions
+		WHERE post_id = $1
+		ORDER BY created_at DESC, replaced_at DESC
+	`
+
+	rows, err := db.QueryContext(ctx, query, id)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision PostRevision
+
+		if err := rows.Scan(&revision.Text, &revision.Image, &revision.CreatedAt); err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		history = append(history, &revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return history, http.StatusOK, nil
+}
+
+// UnschedulePost cancels a scheduled post, keeping it as a draft
+func (p *Post) UnschedulePost(id string, sessionId string) (*Post, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		UPDATE posts
+		SET status = 'draft', publish_at = NULL, updated_at = $1
+		WHERE id = $2 AND author_id = $3 AND status = 'scheduled' AND deleted_at IS NULL
+	` + postReturning
+
+	post, err := scanPost(db.QueryRowContext(ctx, query, time.Now(), id, userId))
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	err = hydratePosts(ctx, []*Post{post}, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return post, http.StatusOK, nil
+}
+
+// GetUnpublishedPosts lists the caller's drafts, most recently edited first,
+// or scheduled posts, soonest first
+func (p *Post) GetUnpublishedPosts(status string, sessionId string) ([]*Post, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	order := "p.updated_at DESC, p.id DESC"
+	if status == PostScheduled {
+		order = "p.publish_at, p.id"
+	}
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		WHERE p.author_id = $1 AND p.status = $2 AND p.deleted_at IS NULL
+		ORDER BY ` + order
+
+	posts, err := queryPosts(ctx, query, userId, status)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	err = hydratePosts(ctx, posts, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return posts, http.StatusOK, nil
+}
+
+// publishScheduledPosts publishes the scheduled posts that are due. Rows
+// locked by another replica are skipped and the status check is repeated
+// under the lock, so each post is published, and announced, exactly once.
+func publishScheduledPosts(ctx context.Context) error {
+	query := `
+		UPDATE posts
+		SET status = 'published', created_at = NOW(), updated_at = NOW()
+		WHERE id IN (
+			SELECT id
+			FROM posts
+			WHERE status = 'scheduled' AND publish_at <= NOW() AND deleted_at IS NULL
+			ORDER BY publish_at
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		) AND status = 'scheduled'
+	` + postReturning
+
+	posts, err := queryPosts(ctx, query)
+
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		announcePost(ctx, *post)
 	}
 
-	return &body, nil
+	return nil
 }
 
+// DeletePost moves a post to the trash, where its author can restore it
+// until it is purged
 func (p *Post) DeletePost(id string, sessionId string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -204,16 +920,227 @@ func (p *Post) DeletePost(id string, sessionId string) error {
 		return errors.New("unauthorized")
 	}
 
+	// A deleted post no longer takes up one of its author's pins
 	query := `
-		DELETE FROM posts
-		WHERE id = $1 AND author_id = $2
+		WITH deleted AS (
+			UPDATE posts
+			SET deleted_at = $1
+			WHERE id = $2 AND author_id = $3 AND deleted_at IS NULL
+			RETURNING id, status
+		), unpinned AS (
+			DELETE FROM pinned_posts
+			WHERE post_id IN (SELECT id FROM deleted)
+		)
+		SELECT status FROM deleted
 	`
 
-	_, err = db.ExecContext(ctx, query, id, userId)
+	var status string
+	err = db.QueryRowContext(ctx, query, time.Now(), id, userId).Scan(&status)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
 
 	if err != nil {
 		return err
 	}
 
+	// Nobody else has seen a draft or scheduled post
+	if status == PostPublished {
+		publishPost(ctx, EventPostDeleted, Post{ID: id, AuthorID: userId})
+	}
+
 	return nil
 }
+
+// GetTrash lists the caller's deleted posts that can still be restored,
+// most recently deleted first
+func (p *Post) GetTrash(sessionId string) ([]*Post, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		WHERE p.author_id = $1 AND p.deleted_at > $2 AND p.deleted_by IS NULL
+		ORDER BY p.deleted_at DESC, p.id DESC
+	`
+
+	posts, err := queryPosts(ctx, query, userId, time.Now().Add(-trashRetention))
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	err = hydratePosts(ctx, posts, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return posts, http.StatusOK, nil
+}
+
+// RestorePost takes a post back out of the trash. Posts removed by a
+// moderator can't be restored.
+func (p *Post) RestorePost(id string, sessionId string) (*Post, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		UPDATE posts
+		SET deleted_at = NULL
+		WHERE id = $1 AND author_id = $2 AND deleted_at > $3 AND deleted_by IS NULL
+	` + postReturning
+
+	restored, err := scanPost(db.QueryRowContext(ctx, query, id, userId, time.Now().Add(-trashRetention)))
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	// Timelines dropped the post when it was deleted, so it is sent again as
+	// a new post
+	if restored.Status == PostPublished {
+		publishPost(ctx, EventPostCreated, *restored)
+	}
+
+	err = hydratePosts(ctx, []*Post{restored}, userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return restored, http.StatusOK, nil
+}
+
+// MarkSensitive lets moderators mark a post sensitive. Its author can't take
+// the mark off again.
+func (p *Post) MarkSensitive(id string, sessionId string) (*Post, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	moderatorId, status, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return nil, status, err
+	}
+
+	query := `
+		UPDATE posts
+		SET sensitive = TRUE, sensitive_forced = TRUE
+		WHERE id = $1 AND deleted_at IS NULL
+	` + postReturning
+
+	marked, err := scanPost(db.QueryRowContext(ctx, query, id))
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToMarkSensitive")
+	}
+
+	if marked.Status == PostPublished {
+		publishPost(ctx, EventPostUpdated, *marked)
+	}
+
+	err = hydratePosts(ctx, []*Post{marked}, moderatorId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return marked, http.StatusOK, nil
+}
+
+// purgeDeletedPosts permanently removes posts that have been in the trash
+// longer than the retention period. Likes, reactions, tags, mentions,
+// bookmarks, revisions and notifications go with them through their foreign
+// keys.
+func purgeDeletedPosts(ctx context.Context) error {
+	query := `
+		DELETE FROM posts
+		WHERE id IN (
+			SELECT id
+			FROM posts
+			WHERE deleted_at < $1
+			LIMIT 500
+		)
+	`
+
+	_, err := db.ExecContext(ctx, query, time.Now().Add(-trashRetention))
+
+	return err
+}
+
+// GetHomeTimeline returns posts by the caller and the accounts they follow
+func (p *Post) GetHomeTimeline(page Page, sessionId string) ([]*Post, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, "", http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	args := []interface{}{userId}
+	after, args, err := page.keyset(args, "p.created_at", "p.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		WHERE (p.author_id = $1 OR p.author_id IN (
+			SELECT followee_id
+			FROM follow_relationships
+			WHERE follower_id = $1
+		))` + visibleClause("p") + after + `
+		ORDER BY p.created_at DESC, p.id DESC
+	` + page.limitClause()
+
+	posts, err := queryPosts(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	posts, next := page.nextPostCursor(posts)
+
+	err = hydratePosts(ctx, posts, userId)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	// Filtered posts are dropped after paging, so a page can come back short
+	// while there are still more after it
+	posts, err = applyFilters(ctx, posts, userId, FilterHome)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return posts, next, http.StatusOK, nil
+}
@@ -2,7 +2,13 @@ package services
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type ProfileTheme string
@@ -13,15 +19,161 @@ const (
 	Standard              = "standard"
 )
 
+// DMPolicy controls who can start direct message conversations with a user
+type DMPolicy string
+
+const (
+	DMEveryone  DMPolicy = "everyone"
+	DMFollowing DMPolicy = "following"
+)
+
 type Profile struct {
-	ID        string       `json:"id"`
-	Username  string       `json:"username"`
-	Nickname  string       `json:"nickname"`
-	Email     string       `json:"email"`
-	Theme     ProfileTheme `json:"theme"`
-	Password  string       `json:"password,omitempty"`
-	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
+	ID             string         `json:"id"`
+	Username       string         `json:"username"`
+	Nickname       string         `json:"nickname"`
+	Email          string         `json:"email"`
+	Theme          ProfileTheme   `json:"theme"`
+	DMPolicy       DMPolicy       `json:"dm_policy"`
+	SensitiveMedia SensitiveMedia `json:"sensitive_media"`
+	Password       string         `json:"password,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// SensitiveMedia is how a user wants posts marked sensitive shown to them:
+// behind a warning they can click through, always expanded, or always hidden.
+// Posts loaded for the user say which applies in their media_display.
+type SensitiveMedia string
+
+const (
+	SensitiveMediaDefault SensitiveMedia = "default"
+	SensitiveMediaExpand  SensitiveMedia = "expand"
+	SensitiveMediaHide    SensitiveMedia = "hide"
+)
+
+// How a post's media should be shown to the viewer it was loaded for, from
+// whether the post is sensitive and the viewer's SensitiveMedia preference
+const (
+	MediaShow = "show"
+	MediaWarn = "warn"
+	MediaHide = "hide"
+)
+
+func mediaDisplay(post *Post, preference SensitiveMedia) string {
+	if !post.Sensitive {
+		return MediaShow
+	}
+
+	switch preference {
+	case SensitiveMediaExpand:
+		return MediaShow
+	case SensitiveMediaHide:
+		return MediaHide
+	}
+
+	return MediaWarn
+}
+
+// sensitiveMediaPreference returns viewerId's SensitiveMedia preference.
+// Anonymous viewers get the default.
+func sensitiveMediaPreference(ctx context.Context, viewerId string) (SensitiveMedia, error) {
+	if viewerId == "" {
+		return SensitiveMediaDefault, nil
+	}
+
+	query := `
+		SELECT profiles.sensitive_media
+		FROM profiles
+		INNER JOIN users ON profiles.id = users.profile_id
+		WHERE users.id = $1
+	`
+
+	var preference SensitiveMedia
+	err := db.QueryRowContext(ctx, query, viewerId).Scan(&preference)
+
+	return preference, err
+}
+
+// loadMediaDisplay sets how each post's media should be shown to viewerId
+func loadMediaDisplay(ctx context.Context, posts []*Post, viewerId string) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	preference, err := sensitiveMediaPreference(ctx, viewerId)
+
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.MediaDisplay = mediaDisplay(post, preference)
+	}
+
+	return nil
+}
+
+// ProfileSettings holds the preferences a user can change; nil fields are
+// left as they are
+type ProfileSettings struct {
+	DMPolicy       *DMPolicy       `json:"dm_policy"`
+	SensitiveMedia *SensitiveMedia `json:"sensitive_media"`
+}
+
+// ProfileSummary is the public part of a profile shown next to other content
+type ProfileSummary struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Nickname string `json:"nickname"`
+}
+
+// ProfileMatch is a profile found by SearchProfiles
+type ProfileMatch struct {
+	ProfileSummary
+	Following     bool `json:"following"`
+	FollowerCount int  `json:"follower_count"`
+}
+
+const defaultProfileSearchLimit = 10
+const maxProfileSearchLimit = 20
+
+// loadProfileSummaries looks up the public profile of each user in userIds,
+// keyed by user ID
+func loadProfileSummaries(ctx context.Context, userIds []string) (map[string]ProfileSummary, error) {
+	summaries := make(map[string]ProfileSummary, len(userIds))
+
+	if len(userIds) == 0 {
+		return summaries, nil
+	}
+
+	query := `
+		SELECT users.id, profiles.username, COALESCE(profiles.nickname, '')
+		FROM users
+		INNER JOIN profiles ON users.profile_id = profiles.id
+		WHERE users.id = ANY($1::uuid[])
+	`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(userIds))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var summary ProfileSummary
+
+		err := rows.Scan(&summary.UserID, &summary.Username, &summary.Nickname)
+
+		if err != nil {
+			return nil, err
+		}
+
+		summaries[summary.UserID] = summary
+	}
+
+	return summaries, rows.Err()
 }
 
 func (p *Profile) GetProfileByUserId(userId string) (*Profile, error) {
@@ -29,7 +181,7 @@ func (p *Profile) GetProfileByUserId(userId string) (*Profile, error) {
 	defer cancel()
 
 	query := `
-		SELECT profiles.id, username, nickname, email, theme
+		SELECT profiles.id, username, nickname, email, theme, dm_policy, sensitive_media
 		FROM profiles
 		INNER JOIN users ON profiles.id = users.profile_id
 		WHERE users.id = $1
@@ -44,6 +196,8 @@ func (p *Profile) GetProfileByUserId(userId string) (*Profile, error) {
 		&profile.Nickname,
 		&profile.Email,
 		&profile.Theme,
+		&profile.DMPolicy,
+		&profile.SensitiveMedia,
 	)
 
 	if err != nil {
@@ -52,3 +206,150 @@ func (p *Profile) GetProfileByUserId(userId string) (*Profile, error) {
 
 	return &profile, nil
 }
+
+func (p *Profile) UpdateSettings(settings ProfileSettings, sessionId string) (*Profile, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	if settings.DMPolicy != nil && *settings.DMPolicy != DMEveryone && *settings.DMPolicy != DMFollowing {
+		return nil, http.StatusBadRequest, errors.New("invalidDmPolicy")
+	}
+
+	if settings.SensitiveMedia != nil {
+		switch *settings.SensitiveMedia {
+		case SensitiveMediaDefault, SensitiveMediaExpand, SensitiveMediaHide:
+		default:
+			return nil, http.StatusBadRequest, errors.New("invalidSensitiveMedia")
+		}
+	}
+
+	query := `
+		UPDATE profiles
+		SET dm_policy = COALESCE($1::dm_policy, dm_policy),
+			sensitive_media = COALESCE($3::sensitive_media, sensitive_media)
+		FROM users
+		WHERE users.profile_id = profiles.id AND users.id = $2
+	`
+
+	_, err = db.ExecContext(ctx, query, settings.DMPolicy, userId, settings.SensitiveMedia)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	profile, err := p.GetProfileByUserId(userId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return profile, http.StatusOK, nil
+}
+
+// SearchProfiles finds profiles whose username or nickname starts with, or
+// closely resembles, q. Exact username matches come first, then accounts the
+// caller follows, then accounts with the most followers.
+func (p *Profile) SearchProfiles(q string, limit int, sessionId string) ([]*ProfileMatch, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	q = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(q), "@"))
+
+	if q == "" {
+		return nil, http.StatusBadRequest, errors.New("queryRequired")
+	}
+
+	if limit <= 0 {
+		limit = defaultProfileSearchLimit
+	}
+
+	if limit > maxProfileSearchLimit {
+		limit = maxProfileSearchLimit
+	}
+
+	// Treat LIKE wildcards typed by the user as plain characters
+	prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(q) + "%"
+
+	var viewer sql.NullString
+	if id := viewerId(ctx, sessionId); id != "" {
+		viewer = sql.NullString{String: id, Valid: true}
+	}
+
+	args := []interface{}{q, prefix, viewer, limit}
+	filters := notSuspendedClause("users.id") + notLimitedClause("users.id", 3)
+
+	if viewer.Valid {
+		filters += notBlockedClause("users.id", 3)
+	}
+
+	query := `
+		SELECT users.id, profiles.username, COALESCE(profiles.nickname, ''),
+			EXISTS (
+				SELECT 1
+				FROM follow_relationships
+				WHERE followee_id = users.id AND follower_id = $3
+			) AS following,
+			(
+				SELECT COUNT(*)
+				FROM follow_relationships
+				WHERE followee_id = users.id
+			) AS follower_count
+		FROM users
+		INNER JOIN profiles ON users.profile_id = profiles.id
+		WHERE (
+			lower(profiles.username) LIKE $2
+			OR lower(COALESCE(profiles.nickname, '')) LIKE $2
+			OR lower(profiles.username) % $1
+			OR lower(COALESCE(profiles.nickname, '')) % $1
+		)` + filters + `
+		ORDER BY
+			lower(profiles.username) = $1 DESC,
+			following DESC,
+			follower_count DESC,
+			GREATEST(
+				similarity(lower(profiles.username), $1),
+				similarity(lower(COALESCE(profiles.nickname, '')), $1)
+			) DESC,
+			profiles.username
+		LIMIT $4
+	`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	matches := []*ProfileMatch{}
+
+	for rows.Next() {
+		var match ProfileMatch
+		err := rows.Scan(
+			&match.UserID,
+			&match.Username,
+			&match.Nickname,
+			&match.Following,
+			&match.FollowerCount,
+		)
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		matches = append(matches, &match)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return matches, http.StatusOK, nil
+}
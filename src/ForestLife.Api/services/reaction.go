@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// Forest-themed reactions offered when no custom set is configured
+var DefaultReactions = []string{"🌲", "🍄", "🌿", "🦉", "🔥"}
+
+var reactions = DefaultReactions
+
+type Reaction struct {
+	Reaction string `json:"reaction"`
+}
+
+type ReactionCount struct {
+	Reaction string `json:"reaction"`
+	Count    int    `json:"count"`
+}
+
+// SetReactions replaces the set of reactions users can leave on posts
+func SetReactions(set []string) {
+	if len(set) == 0 {
+		reactions = DefaultReactions
+		return
+	}
+
+	reactions = set
+}
+
+func (r *Reaction) GetReactions() []string {
+	return reactions
+}
+
+func isValidReaction(reaction string) bool {
+	for _, r := range reactions {
+		if r == reaction {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Reaction) React(postId string, reaction string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	if !isValidReaction(reaction) {
+		return http.StatusBadRequest, errors.New("invalidReaction")
+	}
+
+	// Only posts the caller can see can be reacted to
+	query := `
+		WITH target AS (
+			SELECT p.id
+			FROM posts p
+			WHERE p.id = $1` + visibleClause("p") + notLimitedClause("p.author_id", 2) + `
+		), reacted AS (
+			INSERT INTO post_reactions (post_id, user_id, reaction)
+			SELECT id, $2, $3
+			FROM target
+			ON CONFLICT ON CONSTRAINT UQ_post_reactions DO NOTHING
+			RETURNING post_id
+		)
+		SELECT EXISTS (SELECT 1 FROM target), EXISTS (SELECT 1 FROM reacted)
+	`
+
+	var found, reacted bool
+	err = db.QueryRowContext(ctx, query, postId, userId, reaction).Scan(&found, &reacted)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToReact")
+	}
+
+	if !found {
+		return http.StatusNotFound, errors.New("notFound")
+	}
+
+	if reacted {
+		notifyPostAuthor(ctx, postId, userId, NotificationReaction)
+	}
+
+	return http.StatusOK, nil
+}
+
+func (r *Reaction) Unreact(postId string, reaction string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		DELETE FROM post_reactions
+		WHERE post_id = $1 AND user_id = $2 AND reaction = $3
+	`
+
+	_, err = db.ExecContext(ctx, query, postId, userId, reaction)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToUnreact")
+	}
+
+	return http.StatusOK, nil
+}
+
+// loadReactions fills in aggregated reaction counts for each post, along with
+// the reactions the viewer has left when a viewer is known
+func loadReactions(ctx context.Context, posts []*Post, viewerId string) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byId := make(map[string]*Post, len(posts))
+	ids := make([]string, 0, len(posts))
+
+	for _, p := range posts {
+		p.Reactions = []ReactionCount{}
+		p.MyReactions = []string{}
+		byId[p.ID] = p
+		ids = append(ids, p.ID)
+	}
+
+	countQuery := `
+		SELECT post_id, reaction, COUNT(*)
+		FROM post_reactions
+		WHERE post_id = ANY($1::uuid[])
+		GROUP BY post_id, reaction
+	`
+
+	rows, err := db.QueryContext(ctx, countQuery, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	counts := make(map[string]map[string]int, len(posts))
+
+	for rows.Next() {
+		var postId, reaction string
+		var count int
+
+		err := rows.Scan(&postId, &reaction, &count)
+
+		if err != nil {
+			return err
+		}
+
+		if counts[postId] == nil {
+			counts[postId] = map[string]int{}
+		}
+
+		counts[postId][reaction] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Keep the configured order so clients render a stable reaction bar
+	for postId, postCounts := range counts {
+		for _, reaction := range reactions {
+			if count, ok := postCounts[reaction]; ok {
+				byId[postId].Reactions = append(byId[postId].Reactions, ReactionCount{
+					Reaction: reaction,
+					Count:    count,
+				})
+			}
+		}
+	}
+
+	if viewerId == "" {
+		return nil
+	}
+
+	mineQuery := `
+		SELECT post_id, reaction
+		FROM post_reactions
+		WHERE post_id = ANY($1::uuid[]) AND user_id = $2
+		ORDER BY created_at
+	`
+
+	rows, err = db.QueryContext(ctx, mineQuery, pq.Array(ids), viewerId)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var postId, reaction string
+
+		err := rows.Scan(&postId, &reaction)
+
+		if err != nil {
+			return err
+		}
+
+		byId[postId].MyReactions = append(byId[postId].MyReactions, reaction)
+	}
+
+	return rows.Err()
+}
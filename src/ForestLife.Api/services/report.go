@@ -0,0 +1,691 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+var reportCategories = []string{"spam", "harassment", "hate", "violence", "sexual", "misinformation", "other"}
+
+// Moderation actions. Assign, resolve and dismiss change the report itself;
+// the rest are taken against the reported account or post when resolving,
+// against an account directly by changing its state, or on held posts.
+const (
+	ActionAssign      = "assign"
+	ActionResolve     = "resolve"
+	ActionDismiss     = "dismiss"
+	ActionDeletePost  = "delete_post"
+	ActionApprovePost = "approve_post"
+	ActionWarn        = "warn"
+	ActionLimit       = "limit"
+	ActionSuspend     = "suspend"
+	ActionRestore     = "restore"
+)
+
+const maxReportCommentLength = 1000
+const maxModeratorNoteLength = 1000
+
+// Report is a user's report of a post or an account. When reporting, only
+// PostID or AccountID, Category and Comment are read.
+type Report struct {
+	ID         string             `json:"id"`
+	Category   string             `json:"category"`
+	Comment    string             `json:"comment"`
+	Status     string             `json:"status"`
+	PostID     *string            `json:"post_id"`
+	AccountID  string             `json:"account_id"`
+	Reporter   *ProfileSummary    `json:"reporter,omitempty"`
+	Account    *ProfileSummary    `json:"account,omitempty"`
+	AssignedTo *ProfileSummary    `json:"assigned_to"`
+	Actions    []ModerationAction `json:"actions,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	ResolvedAt *time.Time         `json:"resolved_at"`
+
+	reporterId string
+	assignedId *string
+}
+
+type ModerationAction struct {
+	ID        string          `json:"id"`
+	Action    string          `json:"action"`
+	Moderator *ProfileSummary `json:"moderator"`
+	Note      string          `json:"note"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ReportResolution closes a report, taking Actions against the reported
+// account or post first. Reason and ExpiresAt apply to a limit or suspension.
+type ReportResolution struct {
+	Actions   []string   `json:"actions"`
+	Note      string     `json:"note"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func isReportCategory(category string) bool {
+	for _, c := range reportCategories {
+		if c == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateReport reports a post, or an account when no post is given, to the
+// moderators
+func (r *Report) CreateReport(report Report, sessionId string) (*Report, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	if !isReportCategory(report.Category) {
+		return nil, http.StatusBadRequest, errors.New("invalidCategory")
+	}
+
+	report.Comment = strings.TrimSpace(report.Comment)
+
+	if utf8.RuneCountInString(report.Comment) > maxReportCommentLength {
+		return nil, http.StatusBadRequest, errors.New("commentTooLong")
+	}
+
+	// A reported post is reported along with its author
+	if report.PostID != nil {
+		query := `
+			SELECT p.author_id
+			FROM posts p
+			WHERE p.id = $1` + visibleClause("p")
+
+		err = db.QueryRowContext(ctx, query, *report.PostID).Scan(&report.AccountID)
+
+		if err == sql.ErrNoRows {
+			return nil, http.StatusNotFound, errors.New("notFound")
+		}
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+	}
+
+	if report.AccountID == "" {
+		return nil, http.StatusBadRequest, errors.New("targetRequired")
+	}
+
+	if report.AccountID == userId {
+		return nil, http.StatusBadRequest, errors.New("cannotReportSelf")
+	}
+
+	// The same reporter can't pile up open reports about the same thing
+	query := `
+		INSERT INTO reports (reporter_id, account_id, post_id, category, comment)
+		SELECT $1, users.id, $3, $4, $5
+		FROM users
+		WHERE users.id = $2 AND NOT EXISTS (
+			SELECT 1
+			FROM reports
+			WHERE reporter_id = $1
+				AND account_id = $2
+				AND post_id IS NOT DISTINCT FROM $3::uuid
+				AND status = 'open'
+		)
+		RETURNING id, status, created_at
+	`
+
+	err = db.QueryRowContext(ctx, query, userId, report.AccountID, report.PostID, report.Category, report.Comment).Scan(
+		&report.ID,
+		&report.Status,
+		&report.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusConflict, errors.New("alreadyReported")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToReport")
+	}
+
+	return &report, http.StatusOK, nil
+}
+
+const reportColumns = `reports.id, reports.category, reports.comment, reports.status, reports.post_id,
+	reports.account_id, reports.reporter_id, reports.assigned_to, reports.created_at, reports.resolved_at`
+
+func scanReport(row rowScanner) (*Report, error) {
+	var report Report
+
+	err := row.Scan(
+		&report.ID,
+		&report.Category,
+		&report.Comment,
+		&report.Status,
+		&report.PostID,
+		&report.AccountID,
+		&report.reporterId,
+		&report.assignedId,
+		&report.CreatedAt,
+		&report.ResolvedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// loadReportProfiles fills in the profiles of everyone involved in reports
+func loadReportProfiles(ctx context.Context, reports []*Report) error {
+	ids := []string{}
+
+	for _, report := range reports {
+		ids = append(ids, report.reporterId, report.AccountID)
+
+		if report.assignedId != nil {
+			ids = append(ids, *report.assignedId)
+		}
+	}
+
+	profiles, err := loadProfileSummaries(ctx, ids)
+
+	if err != nil {
+		return err
+	}
+
+	for _, report := range reports {
+		if profile, ok := profiles[report.reporterId]; ok {
+			report.Reporter = &profile
+		}
+
+		if profile, ok := profiles[report.AccountID]; ok {
+			report.Account = &profile
+		}
+
+		if report.assignedId != nil {
+			if profile, ok := profiles[*report.assignedId]; ok {
+				report.AssignedTo = &profile
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetReports pages through the moderation queue, newest first. status
+// defaults to open reports; assignedToMe keeps only reports assigned to the
+// caller.
+func (r *Report) GetReports(status string, assignedToMe bool, page Page, sessionId string) ([]*Report, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	moderatorId, code, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return nil, "", code, err
+	}
+
+	if status == "" {
+		status = ReportOpen
+	}
+
+	if status != ReportOpen && status != ReportResolved && status != ReportDismissed {
+		return nil, "", http.StatusBadRequest, errors.New("invalidStatus")
+	}
+
+	args := []interface{}{status}
+	filters := ""
+
+	if assignedToMe {
+		args = append(args, moderatorId)
+		filters += fmt.Sprintf(" AND reports.assigned_to = $%d", len(args))
+	}
+
+	after, args, err := page.keyset(args, "reports.created_at", "reports.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE reports.status = $1` + filters + after + `
+		ORDER BY reports.created_at DESC, reports.id DESC
+	` + page.limitClause()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	reports := []*Report{}
+
+	for rows.Next() {
+		report, err := scanReport(rows)
+
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		reports = append(reports, report)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	next := ""
+	if page.hasMore(len(reports)) {
+		reports = reports[:page.size()]
+		last := reports[len(reports)-1]
+		next = timeCursor(last.CreatedAt, last.ID)
+	}
+
+	if err := loadReportProfiles(ctx, reports); err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return reports, next, http.StatusOK, nil
+}
+
+// GetReport returns a report with every action taken on it
+func (r *Report) GetReport(id string, sessionId string) (*Report, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, status, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return nil, status, err
+	}
+
+	report, err := loadReport(ctx, id)
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return report, http.StatusOK, nil
+}
+
+func loadReport(ctx context.Context, id string) (*Report, error) {
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE reports.id = $1
+	`
+
+	report, err := scanReport(db.QueryRowContext(ctx, query, id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	actionQuery := `
+		SELECT id, action, moderator_id, note, created_at
+		FROM moderation_actions
+		WHERE report_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := db.QueryContext(ctx, actionQuery, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	moderatorIds := map[string]*string{}
+	report.Actions = []ModerationAction{}
+
+	for rows.Next() {
+		var action ModerationAction
+		var moderatorId *string
+
+		if err := rows.Scan(&action.ID, &action.Action, &moderatorId, &action.Note, &action.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		moderatorIds[action.ID] = moderatorId
+		report.Actions = append(report.Actions, action)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, moderatorId := range moderatorIds {
+		if moderatorId != nil {
+			ids = append(ids, *moderatorId)
+		}
+	}
+
+	profiles, err := loadProfileSummaries(ctx, ids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, action := range report.Actions {
+		if moderatorId := moderatorIds[action.ID]; moderatorId != nil {
+			if profile, ok := profiles[*moderatorId]; ok {
+				report.Actions[i].Moderator = &profile
+			}
+		}
+	}
+
+	return report, loadReportProfiles(ctx, []*Report{report})
+}
+
+// lockOpenReport locks an open report for the rest of tx
+func lockOpenReport(ctx context.Context, tx *sql.Tx, id string) (*Report, int, error) {
+	query := `
+		SELECT ` + reportColumns + `
+		FROM reports
+		WHERE reports.id = $1
+		FOR UPDATE
+	`
+
+	report, err := scanReport(tx.QueryRowContext(ctx, query, id))
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if report.Status != ReportOpen {
+		return nil, http.StatusConflict, errors.New("reportClosed")
+	}
+
+	return report, http.StatusOK, nil
+}
+
+func recordAction(ctx context.Context, tx *sql.Tx, reportId string, moderatorId string, action string, note string) error {
+	query := `
+		INSERT INTO moderation_actions (report_id, moderator_id, action, note)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := tx.ExecContext(ctx, query, reportId, moderatorId, action, note)
+
+	return err
+}
+
+// recordAccountAction records an action a moderator took against an account
+// outside of a report
+func recordAccountAction(ctx context.Context, tx *sql.Tx, accountId string, moderatorId string, action string, note string) error {
+	query := `
+		INSERT INTO moderation_actions (account_id, moderator_id, action, note)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := tx.ExecContext(ctx, query, accountId, moderatorId, action, note)
+
+	return err
+}
+
+func validateNote(note string) (string, error) {
+	note = strings.TrimSpace(note)
+
+	if utf8.RuneCountInString(note) > maxModeratorNoteLength {
+		return "", errors.New("noteTooLong")
+	}
+
+	return note, nil
+}
+
+// AssignReport assigns an open report to a moderator, the caller when
+// moderatorId is empty
+func (r *Report) AssignReport(id string, moderatorId string, note string, sessionId string) (*Report, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	callerId, status, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return nil, status, err
+	}
+
+	note, err = validateNote(note)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	if moderatorId == "" {
+		moderatorId = callerId
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	if _, status, err := lockOpenReport(ctx, tx, id); err != nil {
+		return nil, status, err
+	}
+
+	query := `
+		UPDATE reports
+		SET assigned_to = users.id
+		FROM users
+		WHERE reports.id = $1 AND users.id = $2 AND users.role IN ('moderator', 'admin')
+	`
+
+	result, err := tx.ExecContext(ctx, query, id, moderatorId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToAssignReport")
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, http.StatusBadRequest, errors.New("notAModerator")
+	}
+
+	if err := recordAction(ctx, tx, id, callerId, ActionAssign, note); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToAssignReport")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToAssignReport")
+	}
+
+	return r.GetReport(id, sessionId)
+}
+
+// DismissReport closes a report without acting on it
+func (r *Report) DismissReport(id string, note string, sessionId string) (*Report, int, error) {
+	return r.closeReport(id, ReportDismissed, ActionDismiss, ReportResolution{Note: note}, sessionId)
+}
+
+// ResolveReport takes the resolution's actions against the reported account
+// or post and closes the report. Each action is recorded with the note.
+func (r *Report) ResolveReport(id string, resolution ReportResolution, sessionId string) (*Report, int, error) {
+	return r.closeReport(id, ReportResolved, ActionResolve, resolution, sessionId)
+}
+
+func (r *Report) closeReport(id string, status string, closing string, resolution ReportResolution, sessionId string) (*Report, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	moderatorId, code, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return nil, code, err
+	}
+
+	note, err := validateNote(resolution.Note)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	seen := map[string]bool{}
+
+	for _, action := range resolution.Actions {
+		if (action != ActionDeletePost && action != ActionWarn && action != ActionLimit && action != ActionSuspend) || seen[action] {
+			return nil, http.StatusBadRequest, errors.New("invalidAction")
+		}
+
+		seen[action] = true
+	}
+
+	if seen[ActionLimit] && seen[ActionSuspend] {
+		return nil, http.StatusBadRequest, errors.New("invalidAction")
+	}
+
+	var change *AccountState
+
+	if seen[ActionLimit] || seen[ActionSuspend] {
+		state := StateLimited
+		if seen[ActionSuspend] {
+			state = StateSuspended
+		}
+
+		validated, err := validateAccountState(AccountState{State: state, Reason: resolution.Reason, ExpiresAt: resolution.ExpiresAt})
+
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		change = &validated
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	report, code, err := lockOpenReport(ctx, tx, id)
+
+	if err != nil {
+		return nil, code, err
+	}
+
+	if len(resolution.Actions) > 0 {
+		if code, err := canModerate(ctx, tx, moderatorId, report.AccountID); err != nil {
+			return nil, code, err
+		}
+	}
+
+	var removed *Post
+
+	if seen[ActionDeletePost] {
+		if report.PostID == nil {
+			return nil, http.StatusBadRequest, errors.New("noPostToDelete")
+		}
+
+		removed, err = removePost(ctx, tx, *report.PostID, moderatorId)
+
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("unableToDeletePost")
+		}
+	}
+
+	if change != nil {
+		if err := setAccountState(ctx, tx, report.AccountID, *change); err != nil {
+			return nil, http.StatusInternalServerError, errors.New("unableToSetState")
+		}
+	}
+
+	// Actions are recorded in a fixed order so the history reads the same
+	// however they were requested
+	for _, action := range []string{ActionDeletePost, ActionWarn, ActionLimit, ActionSuspend} {
+		if !seen[action] {
+			continue
+		}
+
+		if err := recordAction(ctx, tx, id, moderatorId, action, note); err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+	}
+
+	if err := recordAction(ctx, tx, id, moderatorId, closing, note); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE reports SET status = $2, resolved_at = NOW() WHERE id = $1`, id, status)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if removed != nil && removed.Status == PostPublished {
+		publishPost(ctx, EventPostDeleted, Post{ID: removed.ID, AuthorID: removed.AuthorID})
+	}
+
+	if seen[ActionWarn] {
+		postId := ""
+		if report.PostID != nil {
+			postId = *report.PostID
+		}
+
+		// The warning comes from the moderation team rather than one of
+		// its members, so the user is its own actor
+		notify(ctx, report.AccountID, report.AccountID, NotificationWarning, postId)
+	}
+
+	if change != nil {
+		// Trends are cached with the account's activity in them
+		invalidate(ctx, trendsCacheKey)
+	}
+
+	return r.GetReport(id, sessionId)
+}
+
+// removePost deletes a post on behalf of a moderator. Unlike a post its
+// author deleted, it can't be restored from the trash.
+func removePost(ctx context.Context, tx *sql.Tx, postId string, moderatorId string) (*Post, error) {
+	query := `
+		UPDATE posts
+		SET deleted_at = COALESCE(deleted_at, NOW()), deleted_by = $2
+		WHERE id = $1
+	` + postReturning
+
+	removed, err := scanPost(tx.QueryRowContext(ctx, query, postId, moderatorId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM pinned_posts WHERE post_id = $1`, postId)
+
+	return removed, err
+}
@@ -0,0 +1,367 @@
+package services
+
+import (
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Entity types. Links, mentions and hashtags are found in the text as
+// written; bold, italic and code come from a small Markdown subset:
+// **bold**, *italic* or _italic_, and `code`.
+const (
+	EntityMention = "mention"
+	EntityHashtag = "hashtag"
+	EntityLink    = "link"
+	EntityBold    = "bold"
+	EntityItalic  = "italic"
+	EntityCode    = "code"
+)
+
+// richSpan is an entity found while parsing a post. Offsets are rune offsets
+// into the raw text until the markup is stripped, and into the display text
+// after that.
+type richSpan struct {
+	kind  string
+	start int
+	end   int
+	// The username, normalized tag or URL the span refers to
+	value string
+	// Length of the Markdown delimiter on each side of bold, italic and
+	// code spans
+	marker int
+}
+
+// richText is a post's text with its Markdown markup removed and the
+// entities found in it
+type richText struct {
+	display []rune
+	spans   []richSpan
+}
+
+// parseRichText finds the entities in text. Code spans are taken first and
+// their contents are left alone, then links, mentions and hashtags outside
+// them, then emphasis around everything else. Entities never partly
+// overlap, and emphasis doesn't nest.
+func parseRichText(text string) richText {
+	runes := []rune(text)
+	taken := make([]bool, len(runes))
+
+	var spans []richSpan
+
+	take := func(span richSpan) {
+		for i := span.start; i < span.end; i++ {
+			if taken[i] {
+				return
+			}
+		}
+
+		for i := span.start; i < span.end; i++ {
+			taken[i] = true
+		}
+
+		spans = append(spans, span)
+	}
+
+	for _, span := range codeSpans(runes) {
+		take(span)
+	}
+
+	for _, span := range linkSpans(text) {
+		take(span)
+	}
+
+	for _, span := range mentionSpans(runes) {
+		take(span)
+	}
+
+	for _, span := range hashtagSpans(runes) {
+		take(span)
+	}
+
+	spans = append(spans, emphasisSpans(runes, taken)...)
+
+	return stripMarkup(runes, spans)
+}
+
+// codeSpans finds `code` on a single line
+func codeSpans(runes []rune) []richSpan {
+	var spans []richSpan
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '`' {
+			continue
+		}
+
+		for j := i + 1; j < len(runes) && runes[j] != '\n'; j++ {
+			if runes[j] != '`' {
+				continue
+			}
+
+			if j > i+1 {
+				spans = append(spans, richSpan{kind: EntityCode, start: i, end: j + 1, marker: 1})
+				i = j
+			}
+
+			break
+		}
+	}
+
+	return spans
+}
+
+// emphasisSpans finds **bold**, *italic* and _italic_ on a single line,
+// made of runes not already part of another span. Delimiters must hug the
+// emphasized text, and _ only counts at word boundaries so snake_case is
+// left alone.
+func emphasisSpans(runes []rune, taken []bool) []richSpan {
+	var spans []richSpan
+
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+	}
+
+	isSpace := func(i int) bool {
+		return i < 0 || i >= len(runes) || unicode.IsSpace(runes[i])
+	}
+
+	delimiterAt := func(i int, delimiter string) bool {
+		for k, r := range delimiter {
+			if i+k >= len(runes) || taken[i+k] || runes[i+k] != r {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for i := 0; i < len(runes); i++ {
+		for _, delimiter := range []string{"**", "*", "_"} {
+			width := len(delimiter)
+
+			if !delimiterAt(i, delimiter) || isSpace(i+width) || (delimiter == "_" && isWord(i-1)) {
+				continue
+			}
+
+			closer := -1
+
+			for j := i + width + 1; j+width <= len(runes) && runes[j-1] != '\n'; j++ {
+				if !delimiterAt(j, delimiter) || isSpace(j-1) || (delimiter == "_" && isWord(j+width)) {
+					continue
+				}
+
+				// A single * next to another * belongs to a bold delimiter
+				if delimiter == "*" && (delimiterAt(j+1, "*") || runes[j-1] == '*') {
+					continue
+				}
+
+				closer = j
+				break
+			}
+
+			if closer == -1 {
+				continue
+			}
+
+			kind := EntityItalic
+			if delimiter == "**" {
+				kind = EntityBold
+			}
+
+			spans = append(spans, richSpan{kind: kind, start: i, end: closer + width, marker: width})
+
+			for k := i; k < closer+width; k++ {
+				taken[k] = true
+			}
+
+			i = closer + width - 1
+			break
+		}
+	}
+
+	return spans
+}
+
+// stripMarkup removes the Markdown delimiters from runes and moves every span
+// onto the remaining display text
+func stripMarkup(runes []rune, spans []richSpan) richText {
+	removed := make([]bool, len(runes))
+
+	for _, span := range spans {
+		for k := 0; k < span.marker; k++ {
+			removed[span.start+k] = true
+			removed[span.end-1-k] = true
+		}
+	}
+
+	// position[i] is where raw rune i lands in the display text
+	position := make([]int, len(runes)+1)
+	display := make([]rune, 0, len(runes))
+
+	for i, r := range runes {
+		position[i] = len(display)
+
+		if !removed[i] {
+			display = append(display, r)
+		}
+	}
+
+	position[len(runes)] = len(display)
+
+	for i := range spans {
+		spans[i].start = position[spans[i].start+spans[i].marker]
+		spans[i].end = position[spans[i].end-spans[i].marker]
+	}
+
+	// Outer spans before the spans they contain
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+
+		return spans[i].end > spans[j].end
+	})
+
+	return richText{display: display, spans: spans}
+}
+
+// render returns the entities shown to clients, with UTF-16 offsets into the
+// display text, and the display text rendered as HTML. Mentions are kept
+// only when they resolved to a user in users, keyed by lowercase username.
+func (t richText) render(users map[string]Entity) ([]Entity, string) {
+	entities := []Entity{}
+
+	// Rune offsets of each entity, used while rendering
+	var bounds [][2]int
+
+	for _, span := range t.spans {
+		entity := Entity{Type: span.kind}
+
+		switch span.kind {
+		case EntityMention:
+			user, ok := users[strings.ToLower(span.value)]
+
+			if !ok {
+				continue
+			}
+
+			entity.UserID = user.UserID
+			entity.Username = user.Username
+		case EntityHashtag:
+			entity.Tag = span.value
+		case EntityLink:
+			entity.URL = span.value
+		}
+
+		entities = append(entities, entity)
+		bounds = append(bounds, [2]int{span.start, span.end})
+	}
+
+	content := t.renderHTML(entities, bounds)
+
+	offsets := utf16Offsets(t.display)
+
+	for i := range entities {
+		entities[i].Start = offsets[bounds[i][0]]
+		entities[i].End = offsets[bounds[i][1]]
+	}
+
+	return entities, content
+}
+
+// renderHTML renders the display text as HTML. Everything from the post is
+// escaped and the only markup is the tags written here, so the result is safe
+// to insert into a page. Entities are ordered outer first and never partly
+// overlap, so their tags always nest.
+func (t richText) renderHTML(entities []Entity, bounds [][2]int) string {
+	var b strings.Builder
+	var open []int
+
+	next := 0
+
+	b.WriteString("<p>")
+
+	for i := 0; i <= len(t.display); i++ {
+		for len(open) > 0 && bounds[open[len(open)-1]][1] == i {
+			b.WriteString(closeTag(entities[open[len(open)-1]]))
+			open = open[:len(open)-1]
+		}
+
+		if i == len(t.display) {
+			break
+		}
+
+		for next < len(entities) && bounds[next][0] == i {
+			b.WriteString(openTag(entities[next]))
+			open = append(open, next)
+			next++
+		}
+
+		// Entities never span a line break, so paragraphs can't split them
+		switch {
+		case t.display[i] == '\n' && i+1 < len(t.display) && t.display[i+1] == '\n':
+			b.WriteString("</p><p>")
+
+			for i+1 < len(t.display) && t.display[i+1] == '\n' {
+				i++
+			}
+		case t.display[i] == '\n':
+			b.WriteString("<br>")
+		default:
+			b.WriteString(html.EscapeString(string(t.display[i])))
+		}
+	}
+
+	b.WriteString("</p>")
+
+	return b.String()
+}
+
+// utf16Offsets maps each rune offset in runes to its UTF-16 offset, which is
+// how JavaScript, Swift and Java index strings
+func utf16Offsets(runes []rune) []int {
+	offsets := make([]int, len(runes)+1)
+
+	for i, r := range runes {
+		offsets[i+1] = offsets[i] + utf16.RuneLen(r)
+	}
+
+	return offsets
+}
+
+func openTag(entity Entity) string {
+	switch entity.Type {
+	case EntityMention:
+		return `<a href="/@` + url.PathEscape(entity.Username) + `" class="mention" data-user-id="` + html.EscapeString(entity.UserID) + `">`
+	case EntityHashtag:
+		return `<a href="/tags/` + url.PathEscape(entity.Tag) + `" class="hashtag">`
+	case EntityLink:
+		return `<a href="` + html.EscapeString(entity.URL) + `" rel="nofollow noopener noreferrer" target="_blank">`
+	case EntityBold:
+		return "<strong>"
+	case EntityItalic:
+		return "<em>"
+	case EntityCode:
+		return "<code>"
+	}
+
+	return ""
+}
+
+func closeTag(entity Entity) string {
+	switch entity.Type {
+	case EntityMention, EntityHashtag, EntityLink:
+		return "</a>"
+	case EntityBold:
+		return "</strong>"
+	case EntityItalic:
+		return "</em>"
+	case EntityCode:
+		return "</code>"
+	}
+
+	return ""
+}
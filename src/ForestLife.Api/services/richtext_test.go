@@ -0,0 +1,212 @@
+package services
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"unicode/utf16"
+)
+
+var richTextUsers = map[string]Entity{
+	"alice": {UserID: "u1", Username: "alice"},
+}
+
+func TestRichTextOffsets(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []Entity
+	}{
+		{
+			name: "astral plane emoji",
+			text: "🌲🍄 @alice #oak",
+			entities: []Entity{
+				{Type: EntityMention, Start: 5, End: 11, UserID: "u1", Username: "alice"},
+				{Type: EntityHashtag, Start: 12, End: 16, Tag: "oak"},
+			},
+		},
+		{
+			name: "precomposed accent",
+			text: "caf\u00e9 @alice",
+			entities: []Entity{
+				{Type: EntityMention, Start: 5, End: 11, UserID: "u1", Username: "alice"},
+			},
+		},
+		{
+			name: "combining accent",
+			text: "cafe\u0301 @alice",
+			entities: []Entity{
+				{Type: EntityMention, Start: 6, End: 12, UserID: "u1", Username: "alice"},
+			},
+		},
+		{
+			name: "ZWJ sequence and combining mark inside emphasis",
+			text: "👩\u200d🌾 *a\u0310* https://example.com/🌲",
+			entities: []Entity{
+				{Type: EntityItalic, Start: 6, End: 8},
+				{Type: EntityLink, Start: 9, End: 31, URL: "https://example.com/🌲"},
+			},
+		},
+		{
+			name: "offsets count the display text without markup",
+			text: "🌲 **bold** _🍄_ `co🌿de`",
+			entities: []Entity{
+				{Type: EntityBold, Start: 3, End: 7},
+				{Type: EntityItalic, Start: 8, End: 10},
+				{Type: EntityCode, Start: 11, End: 17},
+			},
+		},
+		{
+			name:     "unknown mentions are left out",
+			text:     "🌲 @nobody",
+			entities: []Entity{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed := parseRichText(test.text)
+			entities, _ := parsed.render(richTextUsers)
+
+			if !reflect.DeepEqual(entities, test.entities) {
+				t.Fatalf("got entities %+v, want %+v", entities, test.entities)
+			}
+
+			// Offsets must select the entity's text when a client slices the
+			// display text as UTF-16
+			display := utf16.Encode(parsed.display)
+
+			for _, entity := range entities {
+				if entity.Start < 0 || entity.End > len(display) || entity.Start >= entity.End {
+					t.Errorf("%s offsets %d-%d out of range", entity.Type, entity.Start, entity.End)
+					continue
+				}
+
+				text := string(utf16.Decode(display[entity.Start:entity.End]))
+
+				if entity.Type == EntityLink && text != entity.URL {
+					t.Errorf("link offsets select %q, want %q", text, entity.URL)
+				}
+
+				if entity.Type == EntityMention && text != "@"+entity.Username {
+					t.Errorf("mention offsets select %q, want %q", text, "@"+entity.Username)
+				}
+			}
+		})
+	}
+}
+
+func TestRichTextHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		html string
+	}{
+		{
+			name: "escapes plain text",
+			text: `1 < 2 && "x" != 'y'`,
+			html: `<p>1 &lt; 2 &amp;&amp; &#34;x&#34; != &#39;y&#39;</p>`,
+		},
+		{
+			name: "escapes code and emphasis contents",
+			text: "`<script>` \"**<q>**\"",
+			html: `<p><code>&lt;script&gt;</code> &#34;<strong>&lt;q&gt;</strong>&#34;</p>`,
+		},
+		{
+			name: "escapes link attributes",
+			text: "https://example.com/?a=1&b=2'",
+			html: `<p><a href="https://example.com/?a=1&amp;b=2" rel="nofollow noopener noreferrer" target="_blank">https://example.com/?a=1&amp;b=2</a>&#39;</p>`,
+		},
+		{
+			name: "line breaks and paragraphs",
+			text: "line one\nline <two>\n\npara",
+			html: `<p>line one<br>line &lt;two&gt;</p><p>para</p>`,
+		},
+		{
+			name: "emphasis around a mention",
+			text: "**bold @alice** end",
+			html: `<p><strong>bold <a href="/@alice" class="mention" data-user-id="u1">@alice</a></strong> end</p>`,
+		},
+		{
+			name: "emphasis exactly covering a mention",
+			text: "**@alice**",
+			html: `<p><a href="/@alice" class="mention" data-user-id="u1"><strong>@alice</strong></a></p>`,
+		},
+		{
+			name: "emphasis closing inside a link",
+			text: "*see https://example.com/a*b* ok",
+			html: `<p><em>see <a href="https://example.com/a*b" rel="nofollow noopener noreferrer" target="_blank">https://example.com/a*b</a></em> ok</p>`,
+		},
+		{
+			name: "emphasis opening inside a link",
+			text: "*https://example.com/*x* tail",
+			html: `<p><a href="https://example.com/*x" rel="nofollow noopener noreferrer" target="_blank"><em>https://example.com/*x</em></a> tail</p>`,
+		},
+		{
+			name: "emphasis ending mid mention",
+			text: "**start @ali**ce",
+			html: `<p><strong>start @ali</strong>ce</p>`,
+		},
+		{
+			name: "overlapping emphasis",
+			text: "_a **b_ c**",
+			html: `<p><em>a **b</em> c**</p>`,
+		},
+		{
+			name: "overlapping bold and italic",
+			text: "**a *b** c*",
+			html: `<p><strong>a *b</strong> c*</p>`,
+		},
+		{
+			name: "emphasis around a hashtag",
+			text: "**x #oak** and @bob",
+			html: `<p><strong>x <a href="/tags/oak" class="hashtag">#oak</a></strong> and @bob</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, html := parseRichText(test.text).render(richTextUsers)
+
+			if html != test.html {
+				t.Errorf("got %s, want %s", html, test.html)
+			}
+
+			checkNesting(t, html)
+		})
+	}
+}
+
+var htmlTagPattern = regexp.MustCompile(`<(/?)([a-z]+)[^>]*>`)
+
+// checkNesting fails the test unless every tag in html is closed in the
+// order it was opened
+func checkNesting(t *testing.T, html string) {
+	t.Helper()
+
+	var open []string
+
+	for _, match := range htmlTagPattern.FindAllStringSubmatch(html, -1) {
+		closing, tag := match[1] == "/", match[2]
+
+		if tag == "br" {
+			continue
+		}
+
+		if !closing {
+			open = append(open, tag)
+			continue
+		}
+
+		if len(open) == 0 || open[len(open)-1] != tag {
+			t.Errorf("</%s> doesn't close the innermost open tag in %s", tag, html)
+			return
+		}
+
+		open = open[:len(open)-1]
+	}
+
+	if len(open) > 0 {
+		t.Errorf("unclosed %v in %s", open, html)
+	}
+}
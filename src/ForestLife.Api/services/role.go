@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// requireRole returns the signed in user's id when they hold one of roles
+func requireRole(ctx context.Context, sessionId string, roles ...string) (string, int, error) {
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return "", http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	var role string
+	err = db.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, userId).Scan(&role)
+
+	if err != nil {
+		return "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	for _, r := range roles {
+		if r == role {
+			return userId, http.StatusOK, nil
+		}
+	}
+
+	return "", http.StatusForbidden, errors.New("forbidden")
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SortRelevance = "relevance"
+	SortRecent    = "recent"
+)
+
+type Search struct{}
+
+// SearchParams are the raw search options from the request. Since and Until
+// accept a date (2006-01-02) or an RFC 3339 timestamp.
+type SearchParams struct {
+	Query string
+	Since string
+	Until string
+	Sort  string
+}
+
+// searchQuery is a query string split into operators and the remaining
+// full-text terms
+type searchQuery struct {
+	terms string
+	from  []string
+	tags  []string
+}
+
+// parseSearchQuery pulls from:username and #tag operators out of q. Quoted
+// phrases are left in the terms, where websearch_to_tsquery matches them as
+// phrases.
+func parseSearchQuery(q string) searchQuery {
+	var parsed searchQuery
+	var terms []string
+	var token strings.Builder
+	quoted := false
+
+	flush := func() {
+		word := token.String()
+		token.Reset()
+
+		if word == "" {
+			return
+		}
+
+		if strings.HasPrefix(strings.ToLower(word), "from:") && len(word) > len("from:") {
+			parsed.from = append(parsed.from, strings.ToLower(strings.TrimPrefix(word[len("from:"):], "@")))
+			return
+		}
+
+		if strings.HasPrefix(word, "#") || strings.HasPrefix(word, "＃") {
+			if tags := ExtractHashtags(word); len(tags) == 1 {
+				parsed.tags = append(parsed.tags, tags[0])
+				return
+			}
+		}
+
+		terms = append(terms, word)
+	}
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			token.WriteRune(r)
+			quoted = !quoted
+
+			if !quoted {
+				terms = append(terms, token.String())
+				token.Reset()
+			}
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			flush()
+		default:
+			token.WriteRune(r)
+		}
+	}
+
+	if quoted {
+		terms = append(terms, token.String())
+		token.Reset()
+	}
+
+	flush()
+
+	parsed.terms = strings.Join(terms, " ")
+
+	return parsed
+}
+
+func parseSearchDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return nil, errors.New("invalidDate")
+	}
+
+	return &t, nil
+}
+
+type rankScanner struct {
+	rows *sql.Rows
+	rank *float64
+}
+
+func (r rankScanner) Scan(dest ...interface{}) error {
+	return r.rows.Scan(append(dest, r.rank)...)
+}
+
+func (s *Search) SearchPosts(params SearchParams, page Page, sessionId string) ([]*Post, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	q := parseSearchQuery(params.Query)
+
+	if q.terms == "" && len(q.from) == 0 && len(q.tags) == 0 {
+		return nil, "", http.StatusBadRequest, errors.New("queryRequired")
+	}
+
+	since, err := parseSearchDate(params.Since)
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	until, err := parseSearchDate(params.Until)
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	sort := params.Sort
+	if sort == "" {
+		sort = SortRelevance
+	}
+
+	if sort != SortRelevance && sort != SortRecent {
+		return nil, "", http.StatusBadRequest, errors.New("invalidSort")
+	}
+
+	// Relevance needs text to rank against
+	if q.terms == "" {
+		sort = SortRecent
+	}
+
+	var args []interface{}
+	rank := "0::float8"
+	filters := ""
+
+	if q.terms != "" {
+		args = append(args, q.terms)
+		rank = fmt.Sprintf("ts_rank(p.search_vector, websearch_to_tsquery('english', $%d))::float8", len(args))
+		filters += fmt.Sprintf(" AND p.search_vector @@ websearch_to_tsquery('english', $%d)", len(args))
+	}
+
+	if len(q.from) > 0 {
+		args = append(args, pq.Array(q.from))
+		filters += fmt.Sprintf(`
+			AND p.author_id IN (
+				SELECT users.id
+				FROM users
+				INNER JOIN profiles ON users.profile_id = profiles.id
+				WHERE lower(profiles.username) = ANY($%d::text[])
+			)`, len(args))
+	}
+
+	// Every tag in the query must be on the post
+	for _, tag := range q.tags {
+		args = append(args, tag)
+		filters += fmt.Sprintf(`
+			AND EXISTS (
+				SELECT 1
+				FROM post_tags
+				INNER JOIN tags ON tags.id = post_tags.tag_id
+				WHERE post_tags.post_id = p.id AND tags.name = $%d
+			)`, len(args))
+	}
+
+	if since != nil {
+		args = append(args, *since)
+		filters += fmt.Sprintf(" AND p.created_at >= $%d", len(args))
+	}
+
+	if until != nil {
+		args = append(args, *until)
+		filters += fmt.Sprintf(" AND p.created_at < $%d", len(args))
+	}
+
+	viewer := viewerId(ctx, sessionId)
+
+	args = append(args, sql.NullString{String: viewer, Valid: viewer != ""})
+	filters += notLimitedClause("p.author_id", len(args))
+
+	if viewer != "" {
+		filters += notBlockedClause("p.author_id", len(args))
+	}
+
+	var after, order string
+
+	if sort == SortRelevance {
+		after, args, err = page.rankKeyset(args, "rank", "id")
+		order = "rank DESC, id DESC"
+	} else {
+		after, args, err = page.keyset(args, "created_at", "id")
+		order = "created_at DESC, id DESC"
+	}
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT id, text, image, author_id, created_at, updated_at, status, publish_at, edited_at, edit_count, deleted_at, spoiler_text, sensitive, sensitive_forced, in_reply_to_id, rank
+		FROM (
+			SELECT ` + postColumns + `, ` + rank + ` AS rank
+			FROM posts p
+			WHERE TRUE` + visibleClause("p") + filters + `
+		) results
+		WHERE TRUE` + after + `
+		ORDER BY ` + order + page.limitClause()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	posts := []*Post{}
+	ranks := map[*Post]float64{}
+
+	for rows.Next() {
+		var postRank float64
+
+		post, err := scanPost(rankScanner{rows: rows, rank: &postRank})
+
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		ranks[post] = postRank
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	next := ""
+	if page.hasMore(len(posts)) {
+		posts = posts[:page.size()]
+		last := posts[len(posts)-1]
+
+		if sort == SortRelevance {
+			next = rankCursor(ranks[last], last.ID)
+		} else {
+			next = timeCursor(last.CreatedAt, last.ID)
+		}
+	}
+
+	err = hydratePosts(ctx, posts, viewer)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	posts, err = applyFilters(ctx, posts, viewer, FilterSearch)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return posts, next, http.StatusOK, nil
+}
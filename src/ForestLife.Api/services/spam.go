@@ -0,0 +1,454 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Why a post was held for review
+const (
+	HeldLinks     = "links"
+	HeldDuplicate = "duplicate"
+)
+
+// postingLimits caps how fast an account can post and how many links a post
+// can have before it is held for review
+type postingLimits struct {
+	burst     int
+	sustained int
+	links     int
+}
+
+// Posts allowed per window. Drafts don't count until they go out.
+const postBurstWindow = time.Minute
+const postSustainedWindow = time.Hour
+
+var accountLimits = postingLimits{burst: 10, sustained: 120, links: 4}
+
+// Accounts younger than newAccountAge get stricter limits
+const newAccountAge = 7 * 24 * time.Hour
+
+var newAccountLimits = postingLimits{burst: 3, sustained: 20, links: 1}
+
+// A post is held when its text was posted by at least duplicateAccounts other
+// accounts within duplicateWindow. Shorter texts, like greetings, are never
+// treated as duplicates.
+const duplicateAccounts = 2
+const duplicateWindow = 24 * time.Hour
+const minDuplicateLength = 20
+
+// RateLimitError is returned when an account has posted too much. The
+// account can post again after RetryAfter.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return "rateLimited"
+}
+
+// HeldPost is a post waiting for a moderator
+type HeldPost struct {
+	Post   *Post           `json:"post"`
+	Reason string          `json:"reason"`
+	Author *ProfileSummary `json:"author"`
+}
+
+// contentHash hashes text with case and spacing normalized, or returns NULL
+// for text too short to be worth comparing
+func contentHash(text string) sql.NullString {
+	normalized := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+
+	if utf8.RuneCountInString(normalized) < minDuplicateLength {
+		return sql.NullString{}
+	}
+
+	sum := sha256.Sum256([]byte(normalized))
+
+	return sql.NullString{String: hex.EncodeToString(sum[:]), Valid: true}
+}
+
+// limitsFor returns the posting limits that apply to userId
+func limitsFor(ctx context.Context, tx *sql.Tx, userId string) (postingLimits, error) {
+	var joinedAt time.Time
+	err := tx.QueryRowContext(ctx, `SELECT created_at FROM users WHERE id = $1`, userId).Scan(&joinedAt)
+
+	if err != nil {
+		return postingLimits{}, err
+	}
+
+	if time.Since(joinedAt) < newAccountAge {
+		return newAccountLimits, nil
+	}
+
+	return accountLimits, nil
+}
+
+// checkPostingLimits returns the limits that apply to userId, or a
+// RateLimitError when the account has used up its quota. The account is
+// locked for the rest of tx so concurrent posts are counted one at a time.
+func checkPostingLimits(ctx context.Context, tx *sql.Tx, userId string) (postingLimits, int, error) {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('posting:' || $1::text))`, userId)
+
+	if err != nil {
+		return postingLimits{}, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	limits, err := limitsFor(ctx, tx, userId)
+
+	if err != nil {
+		return postingLimits{}, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE created_at > $2),
+			COALESCE(MIN(created_at) FILTER (WHERE created_at > $2), NOW()),
+			COUNT(*),
+			COALESCE(MIN(created_at), NOW())
+		FROM posts
+		WHERE author_id = $1 AND status <> 'draft' AND created_at > $3
+	`
+
+	now := time.Now()
+
+	var burst, sustained int
+	var burstFrom, sustainedFrom time.Time
+
+	err = tx.QueryRowContext(ctx, query, userId, now.Add(-postBurstWindow), now.Add(-postSustainedWindow)).Scan(
+		&burst,
+		&burstFrom,
+		&sustained,
+		&sustainedFrom,
+	)
+
+	if err != nil {
+		return postingLimits{}, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	// A slot frees up once the oldest post in the window falls out of it
+	if sustained >= limits.sustained {
+		return limits, http.StatusTooManyRequests, &RateLimitError{RetryAfter: time.Until(sustainedFrom.Add(postSustainedWindow))}
+	}
+
+	if burst >= limits.burst {
+		return limits, http.StatusTooManyRequests, &RateLimitError{RetryAfter: time.Until(burstFrom.Add(postBurstWindow))}
+	}
+
+	return limits, http.StatusOK, nil
+}
+
+// holdReason returns why a post by userId should be held for review, or an
+// empty string when it can go out
+func holdReason(ctx context.Context, tx *sql.Tx, userId string, text string, limits postingLimits) (string, error) {
+	links := 0
+
+	for _, span := range parseRichText(text).spans {
+		if span.kind == EntityLink {
+			links++
+		}
+	}
+
+	if links > limits.links {
+		return HeldLinks, nil
+	}
+
+	hash := contentHash(text)
+
+	if !hash.Valid {
+		return "", nil
+	}
+
+	query := `
+		SELECT COUNT(DISTINCT author_id)
+		FROM posts
+		WHERE content_hash = $1 AND author_id <> $2 AND created_at > $3
+	`
+
+	var others int
+	err := tx.QueryRowContext(ctx, query, hash, userId, time.Now().Add(-duplicateWindow)).Scan(&others)
+
+	if err != nil {
+		return "", err
+	}
+
+	if others >= duplicateAccounts {
+		return HeldDuplicate, nil
+	}
+
+	return "", nil
+}
+
+// screenPost applies the posting limits and spam checks to a post by userId
+// that is about to go out, returning the reason to hold it, if any
+func screenPost(ctx context.Context, tx *sql.Tx, userId string, text string) (sql.NullString, int, error) {
+	limits, status, err := checkPostingLimits(ctx, tx, userId)
+
+	if err != nil {
+		return sql.NullString{}, status, err
+	}
+
+	reason, err := holdReason(ctx, tx, userId, text, limits)
+
+	if err != nil {
+		return sql.NullString{}, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return sql.NullString{String: reason, Valid: reason != ""}, http.StatusOK, nil
+}
+
+// screenEdit applies the spam checks to new text for a post by userId that
+// has already gone out or is scheduled to. Edits don't count towards the
+// posting limits.
+func screenEdit(ctx context.Context, tx *sql.Tx, userId string, text string) (sql.NullString, error) {
+	limits, err := limitsFor(ctx, tx, userId)
+
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	reason, err := holdReason(ctx, tx, userId, text, limits)
+
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: reason, Valid: reason != ""}, nil
+}
+
+type heldScanner struct {
+	rows   *sql.Rows
+	reason *string
+}
+
+func (s heldScanner) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append(dest, s.reason)...)
+}
+
+// GetHeldPosts pages through the posts waiting for review, newest first
+func (p *Post) GetHeldPosts(page Page, sessionId string) ([]*HeldPost, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	moderatorId, code, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return nil, "", code, err
+	}
+
+	after, args, err := page.keyset(nil, "p.created_at", "p.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT ` + postColumns + `, p.held_reason
+		FROM posts p
+		WHERE p.status = 'held' AND p.deleted_at IS NULL` + after + `
+		ORDER BY p.created_at DESC, p.id DESC
+	` + page.limitClause()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	held := []*HeldPost{}
+	posts := []*Post{}
+
+	for rows.Next() {
+		var reason string
+
+		post, err := scanPost(heldScanner{rows, &reason})
+
+		if err != nil {
+			return nil, "", http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		held = append(held, &HeldPost{Post: post, Reason: reason})
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	next := ""
+	if page.hasMore(len(held)) {
+		held = held[:page.size()]
+		posts = posts[:page.size()]
+		last := posts[len(posts)-1]
+		next = timeCursor(last.CreatedAt, last.ID)
+	}
+
+	authorIds := make([]string, 0, len(posts))
+	for _, post := range posts {
+		authorIds = append(authorIds, post.AuthorID)
+	}
+
+	profiles, err := loadProfileSummaries(ctx, authorIds)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	for _, h := range held {
+		if profile, ok := profiles[h.Post.AuthorID]; ok {
+			h.Author = &profile
+		}
+	}
+
+	err = hydratePosts(ctx, posts, moderatorId)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return held, next, http.StatusOK, nil
+}
+
+// lockHeldPost locks a post waiting for review for the rest of tx
+func lockHeldPost(ctx context.Context, tx *sql.Tx, id string) (*Post, int, error) {
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		WHERE p.id = $1 AND p.status = 'held' AND p.deleted_at IS NULL
+		FOR UPDATE
+	`
+
+	post, err := scanPost(tx.QueryRowContext(ctx, query, id))
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return post, http.StatusOK, nil
+}
+
+// ApprovePost lets a held post go out: right away, or when it was scheduled
+// for if that is still to come. A post held over an edit after it was
+// published keeps its place in feeds.
+func (p *Post) ApprovePost(id string, note string, sessionId string) (*Post, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	moderatorId, code, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return nil, code, err
+	}
+
+	note, err = validateNote(note)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	if _, code, err := lockHeldPost(ctx, tx, id); err != nil {
+		return nil, code, err
+	}
+
+	query := `
+		UPDATE posts
+		SET status = CASE WHEN publish_at > NOW() THEN 'scheduled' ELSE 'published' END,
+			publish_at = CASE WHEN publish_at > NOW() THEN publish_at END,
+			created_at = CASE WHEN publish_at > NOW() OR edited_at IS NOT NULL THEN created_at ELSE NOW() END,
+			updated_at = NOW(),
+			held_reason = NULL
+		WHERE id = $1
+	` + postReturning
+
+	approved, err := scanPost(tx.QueryRowContext(ctx, query, id))
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToApprovePost")
+	}
+
+	if err := recordAccountAction(ctx, tx, approved.AuthorID, moderatorId, ActionApprovePost, note); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToApprovePost")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("unableToApprovePost")
+	}
+
+	if approved.Status == PostPublished {
+		announcePost(ctx, *approved)
+	}
+
+	err = hydratePosts(ctx, []*Post{approved}, moderatorId)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return approved, http.StatusOK, nil
+}
+
+// RejectPost removes a held post. Like other posts removed by moderators, its
+// author can't restore it.
+func (p *Post) RejectPost(id string, note string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	moderatorId, code, err := requireRole(ctx, sessionId, RoleModerator, RoleAdmin)
+
+	if err != nil {
+		return code, err
+	}
+
+	note, err = validateNote(note)
+
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	held, code, err := lockHeldPost(ctx, tx, id)
+
+	if err != nil {
+		return code, err
+	}
+
+	if _, err := removePost(ctx, tx, id, moderatorId); err != nil {
+		return http.StatusInternalServerError, errors.New("unableToRejectPost")
+	}
+
+	if err := recordAccountAction(ctx, tx, held.AuthorID, moderatorId, ActionDeletePost, note); err != nil {
+		return http.StatusInternalServerError, errors.New("unableToRejectPost")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return http.StatusInternalServerError, errors.New("unableToRejectPost")
+	}
+
+	return http.StatusOK, nil
+}
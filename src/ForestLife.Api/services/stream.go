@@ -0,0 +1,461 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	EventNotification = "notification"
+	EventPostCreated  = "post.created"
+	EventPostUpdated  = "post.updated"
+	EventPostDeleted  = "post.deleted"
+
+	// Sent when a client resumes from an event that is no longer buffered, so
+	// it knows to refetch instead of assuming it has seen everything
+	EventReset = "reset"
+)
+
+// Events kept in memory for clients resuming with Last-Event-ID
+const streamHistorySize = 1024
+
+// Events buffered per connection. A client that falls this far behind is
+// disconnected and can resume from its last event.
+const streamClientBuffer = 64
+
+type Stream struct{}
+
+type StreamEvent struct {
+	ID   int64           `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	To   []string        `json:"to"`
+}
+
+type streamClient struct {
+	userId string
+	events chan StreamEvent
+	lagged chan struct{}
+}
+
+type streamHub struct {
+	mu      sync.Mutex
+	lastId  int64
+	clients map[string]map[*streamClient]bool
+	history []StreamEvent
+	// Events up to this ID may no longer be in history, either because they
+	// were trimmed or because they were published before this process started
+	forgotten int64
+}
+
+var streams = &streamHub{
+	clients:   map[string]map[*streamClient]bool{},
+	forgotten: time.Now().UnixNano(),
+}
+
+// How long a connection keeps using the keyword filters and sensitive media
+// preference it loaded before loading them again, so changes reach open
+// streams
+const streamFilterRefresh = time.Minute
+
+// Subscription delivers the events addressed to one user. Backlog holds the
+// events missed since the Last-Event-ID the client resumed from.
+type Subscription struct {
+	Backlog []StreamEvent
+	Events  <-chan StreamEvent
+	Lagged  <-chan struct{}
+	client  *streamClient
+
+	matcher        *keywordMatcher
+	sensitiveMedia SensitiveMedia
+	loadedAt       time.Time
+}
+
+// nextId returns increasing event IDs that also survive a restart, since they
+// start from the current time. IDs are assigned by the publishing replica, so
+// the same event has the same ID on every replica.
+func (h *streamHub) nextId() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := time.Now().UnixNano()
+
+	if id <= h.lastId {
+		id = h.lastId + 1
+	}
+
+	h.lastId = id
+
+	return id
+}
+
+func (h *streamHub) publish(event StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Events from other replicas can arrive slightly out of ID order, so
+	// history is kept in arrival order and trimmed by position
+	h.history = append(h.history, event)
+	if len(h.history) > streamHistorySize {
+		trimmed := h.history[len(h.history)-streamHistorySize-1]
+		if trimmed.ID > h.forgotten {
+			h.forgotten = trimmed.ID
+		}
+
+		h.history = h.history[len(h.history)-streamHistorySize:]
+	}
+
+	for _, userId := range event.To {
+		for client := range h.clients[userId] {
+			select {
+			case client.events <- event:
+			default:
+				h.drop(client)
+			}
+		}
+	}
+}
+
+func (h *streamHub) drop(client *streamClient) {
+	if !h.clients[client.userId][client] {
+		return
+	}
+
+	delete(h.clients[client.userId], client)
+	if len(h.clients[client.userId]) == 0 {
+		delete(h.clients, client.userId)
+	}
+
+	close(client.lagged)
+}
+
+func (h *streamHub) subscribe(userId string, lastEventId int64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client := &streamClient{
+		userId: userId,
+		events: make(chan StreamEvent, streamClientBuffer),
+		lagged: make(chan struct{}),
+	}
+
+	// Registering and reading history under the same lock means nothing is
+	// missed or delivered twice between the backlog and live events
+	if h.clients[userId] == nil {
+		h.clients[userId] = map[*streamClient]bool{}
+	}
+
+	h.clients[userId][client] = true
+
+	sub := &Subscription{
+		Events: client.events,
+		Lagged: client.lagged,
+		client: client,
+	}
+
+	if lastEventId == 0 {
+		return sub
+	}
+
+	if lastEventId < h.forgotten {
+		sub.Backlog = append(sub.Backlog, StreamEvent{ID: h.forgotten, Type: EventReset, Data: json.RawMessage("null")})
+	}
+
+	for _, event := range h.history {
+		if event.ID <= lastEventId {
+			continue
+		}
+
+		for _, to := range event.To {
+			if to == userId {
+				sub.Backlog = append(sub.Backlog, event)
+				break
+			}
+		}
+	}
+
+	return sub
+}
+
+// Filter prepares a post event for the user before it is sent, the same way
+// GetHomeTimeline prepares their timeline. Posts a hide filter matches are
+// dropped, those a warn filter matches are annotated, and media display
+// follows the user's sensitive media preference. It must be called from one
+// goroutine at a time.
+func (s *Subscription) Filter(event StreamEvent) (StreamEvent, bool) {
+	if event.Type != EventPostCreated && event.Type != EventPostUpdated {
+		return event, true
+	}
+
+	var post Post
+
+	if err := json.Unmarshal(event.Data, &post); err != nil {
+		return event, true
+	}
+
+	if s.matcher == nil || time.Since(s.loadedAt) > streamFilterRefresh {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		defer cancel()
+
+		matcher, err := loadMatcher(ctx, s.client.userId, FilterHome)
+
+		if err == nil {
+			s.sensitiveMedia, err = sensitiveMediaPreference(ctx, s.client.userId)
+		}
+
+		// Without the filters there's no telling whether the post should
+		// be hidden, so it is left out like a timeline that failed to load
+		if err != nil {
+			log.Println("Unable to load stream preferences:", err)
+			return event, false
+		}
+
+		s.matcher = matcher
+		s.loadedAt = time.Now()
+	}
+
+	if len(s.matcher.filterPosts([]*Post{&post})) == 0 {
+		return event, false
+	}
+
+	display := mediaDisplay(&post, s.sensitiveMedia)
+
+	if len(post.Filtered) == 0 && post.MediaDisplay == display {
+		return event, true
+	}
+
+	post.MediaDisplay = display
+
+	data, err := json.Marshal(post)
+
+	if err != nil {
+		return event, false
+	}
+
+	event.Data = data
+
+	return event, true
+}
+
+// Authorized reports whether sessionId still belongs to the subscribed user.
+// Sessions end when the user signs out or is suspended, and open streams are
+// checked periodically so they end too.
+func (s *Subscription) Authorized(sessionId string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	return err == nil && userId == s.client.userId
+}
+
+func (s *Subscription) Close() {
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+
+	streams.drop(s.client)
+}
+
+// publish sends an event to every connected client of the given users, on
+// whichever replica they are connected to. Events are best effort, so
+// failures are logged rather than failing the change that caused them.
+func publish(ctx context.Context, kind string, data interface{}, to []string) error {
+	if len(to) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(data)
+
+	if err == nil {
+		var event []byte
+		event, err = json.Marshal(StreamEvent{ID: streams.nextId(), Type: kind, Data: payload, To: to})
+
+		if err == nil {
+			err = bus.Publish(ctx, topicStream, event)
+		}
+	}
+
+	if err != nil {
+		log.Println("Unable to publish", kind, "event:", err)
+	}
+
+	return err
+}
+
+// postEvent announces a change to a post. It carries only IDs so it stays
+// small however many followers the author has.
+type postEvent struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	PostID   string `json:"post_id"`
+	AuthorID string `json:"author_id"`
+}
+
+// postAudience returns the users whose home timeline shows posts by authorId
+func postAudience(ctx context.Context, authorId string) ([]string, error) {
+	query := `
+		SELECT follower_id
+		FROM follow_relationships
+		WHERE followee_id = $1
+	`
+
+	rows, err := db.QueryContext(ctx, query, authorId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	audience := []string{authorId}
+
+	for rows.Next() {
+		var followerId string
+
+		if err := rows.Scan(&followerId); err != nil {
+			return nil, err
+		}
+
+		audience = append(audience, followerId)
+	}
+
+	return audience, rows.Err()
+}
+
+// publishPost tells every replica that a post was created, updated or
+// deleted. Each of them then delivers it to the author's audience.
+func publishPost(ctx context.Context, kind string, post Post) {
+	payload, err := json.Marshal(postEvent{ID: streams.nextId(), Type: kind, PostID: post.ID, AuthorID: post.AuthorID})
+
+	if err == nil {
+		err = bus.Publish(ctx, topicPost, payload)
+	}
+
+	if err != nil {
+		log.Println("Unable to publish", kind, "event for post", post.ID+":", err)
+	}
+}
+
+// Post events are resolved by a few workers so the bus listener can go on to
+// other messages meanwhile. Events for the same post always go to the same
+// worker, so they are delivered in the order they were published.
+const postDeliveryWorkers = 4
+const postDeliveryQueue = 256
+
+var postQueues []chan postEvent
+var startPostDelivery sync.Once
+
+func startPostWorkers() {
+	postQueues = make([]chan postEvent, postDeliveryWorkers)
+
+	for i := range postQueues {
+		postQueues[i] = make(chan postEvent, postDeliveryQueue)
+
+		go func(queue <-chan postEvent) {
+			for event := range queue {
+				deliverPost(event)
+			}
+		}(postQueues[i])
+	}
+}
+
+// queuePost hands a post event to its worker. When that worker's queue is
+// full it waits, slowing the bus listener down rather than losing the event.
+func queuePost(event postEvent) {
+	hash := fnv.New32a()
+	hash.Write([]byte(event.PostID))
+
+	postQueues[hash.Sum32()%uint32(len(postQueues))] <- event
+}
+
+// deliverPost hydrates a post event and sends it to the author's home
+// timeline audience. Every replica addresses the event to the whole
+// audience, not just its own clients, so a client can resume from it on any
+// replica. The post is hydrated without a viewer so no one receives another
+// user's reactions.
+func deliverPost(event postEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := deliverPostEvent(ctx, event)
+
+	if err != nil {
+		log.Println("Unable to deliver", event.Type, "event for post", event.PostID+":", err)
+	}
+}
+
+func deliverPostEvent(ctx context.Context, event postEvent) error {
+	audience, err := postAudience(ctx, event.AuthorID)
+
+	if err != nil {
+		return err
+	}
+
+	var data interface{} = map[string]string{"id": event.PostID}
+
+	if event.Type != EventPostDeleted {
+		query := `
+			SELECT ` + postColumns + `
+			FROM posts p
+			WHERE p.id = $1` + visibleClause("p")
+
+		post, err := scanPost(db.QueryRowContext(ctx, query, event.PostID))
+
+		// The post was deleted or hidden before the event got here
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = hydratePosts(ctx, []*Post{post}, "")
+
+		if err != nil {
+			return err
+		}
+
+		data = post
+	}
+
+	payload, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	streams.publish(StreamEvent{ID: event.ID, Type: event.Type, Data: payload, To: audience})
+
+	return nil
+}
+
+func (s *Stream) Subscribe(lastEventId string, sessionId string) (*Subscription, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	var lastId int64
+	if lastEventId != "" {
+		lastId, err = strconv.ParseInt(lastEventId, 10, 64)
+
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("invalidLastEventId")
+		}
+	}
+
+	return streams.subscribe(userId, lastId), http.StatusOK, nil
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SuggestionFollowedBy = "followed_by"
+	SuggestionSharedTags = "shared_tags"
+	SuggestionPopular    = "popular"
+)
+
+const defaultSuggestionLimit = 10
+const maxSuggestionLimit = 30
+
+// Candidates considered from each source before ranking
+const suggestionCandidates = 100
+
+// How many of the caller's followees, or shared tags, are named in a
+// suggestion's explanation
+const suggestionNamed = 3
+
+// Tags used within this many days count as the caller's interests
+const suggestionTagDays = 30
+
+type Suggestion struct{}
+
+type FollowSuggestion struct {
+	Profile     ProfileSummary   `json:"profile"`
+	Reason      string           `json:"reason"`
+	Explanation string           `json:"explanation"`
+	FollowedBy  []ProfileSummary `json:"followed_by,omitempty"`
+	SharedTags  []string         `json:"shared_tags,omitempty"`
+
+	mutuals   int
+	mutualIds []string
+	tagCount  int
+	followers int
+	score     float64
+}
+
+// suggestionFilter leaves out the caller bound to $1, accounts they already
+// follow, have dismissed or are blocked from, and limited or suspended
+// accounts
+func suggestionFilter(userCol string) string {
+	return fmt.Sprintf(`
+		AND %[1]s <> $1
+		AND NOT EXISTS (
+			SELECT 1
+			FROM follow_relationships
+			WHERE follower_id = $1 AND followee_id = %[1]s
+		)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM suggestion_dismissals
+			WHERE user_id = $1 AND dismissed_id = %[1]s
+		)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM users
+			WHERE users.id = %[1]s AND users.state <> 'active'
+		)`, userCol) + notBlockedClause(userCol, 1)
+}
+
+func displayName(profile ProfileSummary) string {
+	if profile.Nickname != "" {
+		return profile.Nickname
+	}
+
+	return "@" + profile.Username
+}
+
+func (s *FollowSuggestion) explain() {
+	switch {
+	case s.mutuals > 0:
+		s.Reason = SuggestionFollowedBy
+
+		names := []string{}
+		for _, profile := range s.FollowedBy {
+			names = append(names, displayName(profile))
+		}
+
+		// Followees may have been removed between queries; fall back to a count
+		if len(names) == 0 {
+			s.Explanation = fmt.Sprintf("Followed by %d people you follow", s.mutuals)
+			return
+		}
+
+		s.Explanation = "Followed by " + names[0]
+
+		if others := s.mutuals - 1; others == 1 {
+			s.Explanation += " and 1 other"
+		} else if others > 1 {
+			s.Explanation += fmt.Sprintf(" and %d others", others)
+		}
+	case s.tagCount > 0:
+		s.Reason = SuggestionSharedTags
+
+		tags := make([]string, len(s.SharedTags))
+		for i, tag := range s.SharedTags {
+			tags[i] = "#" + tag
+		}
+
+		s.Explanation = "Also posts about " + strings.Join(tags, ", ")
+	default:
+		s.Reason = SuggestionPopular
+		s.Explanation = "Popular on Forest Life"
+	}
+}
+
+// GetFollowSuggestions proposes accounts for the caller to follow: those
+// followed by people they follow, those posting about the same hashtags, and
+// popular accounts, ranked by a combination of the three.
+func (s *Suggestion) GetFollowSuggestions(limit int, sessionId string) ([]*FollowSuggestion, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	if limit <= 0 {
+		limit = defaultSuggestionLimit
+	}
+
+	if limit > maxSuggestionLimit {
+		limit = maxSuggestionLimit
+	}
+
+	candidates := map[string]*FollowSuggestion{}
+	candidate := func(id string) *FollowSuggestion {
+		if candidates[id] == nil {
+			candidates[id] = &FollowSuggestion{Profile: ProfileSummary{UserID: id}}
+		}
+
+		return candidates[id]
+	}
+
+	if err := loadFriendsOfFriends(ctx, userId, candidate); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if err := loadSharedTagAuthors(ctx, userId, candidate); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if err := loadPopularAccounts(ctx, userId, candidate); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	if err := loadFollowerCounts(ctx, candidates); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	suggestions := make([]*FollowSuggestion, 0, len(candidates))
+
+	for _, suggestion := range candidates {
+		suggestion.score = 3*float64(suggestion.mutuals) +
+			2*float64(suggestion.tagCount) +
+			math.Log1p(float64(suggestion.followers))
+
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].score != suggestions[j].score {
+			return suggestions[i].score > suggestions[j].score
+		}
+
+		return suggestions[i].Profile.UserID < suggestions[j].Profile.UserID
+	})
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	ids := []string{}
+	for _, suggestion := range suggestions {
+		ids = append(ids, suggestion.Profile.UserID)
+		ids = append(ids, suggestion.mutualIds...)
+	}
+
+	profiles, err := loadProfileSummaries(ctx, ids)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	shown := suggestions[:0]
+
+	for _, suggestion := range suggestions {
+		profile, ok := profiles[suggestion.Profile.UserID]
+		if !ok {
+			continue
+		}
+
+		suggestion.Profile = profile
+
+		for _, mutualId := range suggestion.mutualIds {
+			if mutual, ok := profiles[mutualId]; ok {
+				suggestion.FollowedBy = append(suggestion.FollowedBy, mutual)
+			}
+		}
+
+		suggestion.explain()
+		shown = append(shown, suggestion)
+	}
+
+	return shown, http.StatusOK, nil
+}
+
+// loadFriendsOfFriends finds accounts followed by the people userId follows
+func loadFriendsOfFriends(ctx context.Context, userId string, candidate func(string) *FollowSuggestion) error {
+	query := `
+		SELECT f2.followee_id, COUNT(DISTINCT f1.followee_id),
+			array_to_string((array_agg(f1.followee_id::text))[1:$2], ',')
+		FROM follow_relationships f1
+		INNER JOIN follow_relationships f2 ON f2.follower_id = f1.followee_id
+		WHERE f1.follower_id = $1` + suggestionFilter("f2.followee_id") + `
+		GROUP BY f2.followee_id
+		ORDER BY COUNT(DISTINCT f1.followee_id) DESC
+		LIMIT $3
+	`
+
+	rows, err := db.QueryContext(ctx, query, userId, suggestionNamed, suggestionCandidates)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, mutualIds string
+		var mutuals int
+
+		if err := rows.Scan(&id, &mutuals, &mutualIds); err != nil {
+			return err
+		}
+
+		suggestion := candidate(id)
+		suggestion.mutuals = mutuals
+		suggestion.mutualIds = strings.Split(mutualIds, ",")
+	}
+
+	return rows.Err()
+}
+
+// loadSharedTagAuthors finds accounts posting about the hashtags userId has
+// recently used
+func loadSharedTagAuthors(ctx context.Context, userId string, candidate func(string) *FollowSuggestion) error {
+	query := `
+		WITH interests AS (
+			SELECT DISTINCT post_tags.tag_id
+			FROM post_tags
+			INNER JOIN posts mine ON mine.id = post_tags.post_id
+			WHERE mine.author_id = $1
+				AND mine.created_at > NOW() - $2 * INTERVAL '1 day'` + visibleClause("mine") + `
+		)
+		SELECT p.author_id, COUNT(DISTINCT tags.id),
+			array_to_string((array_agg(DISTINCT tags.name))[1:$3], ',')
+		FROM interests
+		INNER JOIN post_tags ON post_tags.tag_id = interests.tag_id
+		INNER JOIN posts p ON p.id = post_tags.post_id
+		INNER JOIN tags ON tags.id = post_tags.tag_id
+		WHERE p.created_at > NOW() - $2 * INTERVAL '1 day'` + visibleClause("p") + suggestionFilter("p.author_id") + `
+		GROUP BY p.author_id
+		ORDER BY COUNT(DISTINCT tags.id) DESC
+		LIMIT $4
+	`
+
+	rows, err := db.QueryContext(ctx, query, userId, suggestionTagDays, suggestionNamed, suggestionCandidates)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, tags string
+		var count int
+
+		if err := rows.Scan(&id, &count, &tags); err != nil {
+			return err
+		}
+
+		suggestion := candidate(id)
+		suggestion.tagCount = count
+		suggestion.SharedTags = strings.Split(tags, ",")
+	}
+
+	return rows.Err()
+}
+
+// loadPopularAccounts finds the most followed accounts
+func loadPopularAccounts(ctx context.Context, userId string, candidate func(string) *FollowSuggestion) error {
+	query := `
+		SELECT followee_id, COUNT(*)
+		FROM follow_relationships
+		WHERE TRUE` + suggestionFilter("followee_id") + `
+		GROUP BY followee_id
+		ORDER BY COUNT(*) DESC
+		LIMIT $2
+	`
+
+	rows, err := db.QueryContext(ctx, query, userId, suggestionCandidates)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var followers int
+
+		if err := rows.Scan(&id, &followers); err != nil {
+			return err
+		}
+
+		candidate(id).followers = followers
+	}
+
+	return rows.Err()
+}
+
+// loadFollowerCounts fills in the follower counts of candidates found through
+// the other sources, which are used to break ties between them
+func loadFollowerCounts(ctx context.Context, candidates map[string]*FollowSuggestion) error {
+	ids := make([]string, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+
+	query := `
+		SELECT followee_id, COUNT(*)
+		FROM follow_relationships
+		WHERE followee_id = ANY($1::uuid[])
+		GROUP BY followee_id
+	`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var followers int
+
+		if err := rows.Scan(&id, &followers); err != nil {
+			return err
+		}
+
+		candidates[id].followers = followers
+	}
+
+	return rows.Err()
+}
+
+// DismissFollowSuggestion stops dismissId from being suggested to the caller
+func (s *Suggestion) DismissFollowSuggestion(dismissId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		INSERT INTO suggestion_dismissals (user_id, dismissed_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err = db.ExecContext(ctx, query, userId, dismissId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToDismiss")
+	}
+
+	return http.StatusOK, nil
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxTagLength = 100
+
+type Tag struct {
+	Name          string     `json:"name"`
+	PostCount     int        `json:"post_count"`
+	AuthorCount   int        `json:"author_count"`
+	PostsLastDay  int        `json:"posts_last_day"`
+	PostsLastWeek int        `json:"posts_last_week"`
+	FirstUsedAt   *time.Time `json:"first_used_at"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// NormalizeTag folds a hashtag to the form it is stored and looked up by, so
+// #Forest, #FOREST and #ｆｏｒｅｓｔ all refer to the same tag
+func NormalizeTag(tag string) string {
+	tag = strings.TrimLeft(tag, "#＃")
+	return strings.ToLower(norm.NFKC.String(tag))
+}
+
+// ExtractHashtags returns the normalized, de-duplicated hashtags in text.
+// Hashtags inside links and code spans don't count.
+func ExtractHashtags(text string) []string {
+	var tags []string
+	seen := map[string]bool{}
+
+	for _, span := range parseRichText(text).spans {
+		if span.kind != EntityHashtag || seen[span.value] {
+			continue
+		}
+
+		seen[span.value] = true
+		tags = append(tags, span.value)
+	}
+
+	return tags
+}
+
+// hashtagSpans finds the hashtags in runes. A hashtag starts with # after a
+// non-word character and runs over letters, marks, digits and underscores;
+// purely numeric tags such as #1 are ignored.
+func hashtagSpans(runes []rune) []richSpan {
+	var spans []richSpan
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' && runes[i] != '＃' {
+			continue
+		}
+
+		// Skip things like "a#b" or HTML entities such as "&#123"
+		if i > 0 && (isTagRune(runes[i-1]) || runes[i-1] == '&') {
+			continue
+		}
+
+		end := i + 1
+		hasLetter := false
+
+		for end < len(runes) && isTagRune(runes[end]) {
+			if !unicode.IsDigit(runes[end]) && runes[end] != '_' {
+				hasLetter = true
+			}
+
+			end++
+		}
+
+		if end == i+1 || !hasLetter {
+			continue
+		}
+
+		tag := NormalizeTag(string(runes[i+1 : end]))
+
+		if utf8.RuneCountInString(tag) <= maxTagLength {
+			spans = append(spans, richSpan{kind: EntityHashtag, start: i, end: end, value: tag})
+		}
+
+		i = end - 1
+	}
+
+	return spans
+}
+
+// syncTags links a post to exactly the hashtags found in its text, creating
+// tags on first use and dropping links the text no longer contains
+func syncTags(ctx context.Context, tx *sql.Tx, postId string, text string) error {
+	tags := ExtractHashtags(text)
+
+	if len(tags) > 0 {
+		tagQuery := `
+			INSERT INTO tags (name)
+			SELECT unnest($1::text[])
+			ON CONFLICT (name) DO NOTHING
+		`
+
+		_, err := tx.ExecContext(ctx, tagQuery, pq.Array(tags))
+
+		if err != nil {
+			return err
+		}
+
+		linkQuery := `
+			INSERT INTO post_tags (post_id, tag_id)
+			SELECT $1, id
+			FROM tags
+			WHERE name = ANY($2::text[])
+			ON CONFLICT DO NOTHING
+		`
+
+		_, err = tx.ExecContext(ctx, linkQuery, postId, pq.Array(tags))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	unlinkQuery := `
+		DELETE FROM post_tags
+		WHERE post_id = $1 AND tag_id NOT IN (
+			SELECT id
+			FROM tags
+			WHERE name = ANY($2::text[])
+		)
+	`
+
+	_, err := tx.ExecContext(ctx, unlinkQuery, postId, pq.Array(tags))
+
+	return err
+}
+
+func (t *Tag) GetTag(name string) (*Tag, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		SELECT
+			tags.name,
+			COUNT(p.id),
+			COUNT(DISTINCT p.author_id),
+			COUNT(p.id) FILTER (WHERE p.created_at > NOW() - INTERVAL '1 day'),
+			COUNT(p.id) FILTER (WHERE p.created_at > NOW() - INTERVAL '7 days'),
+			MIN(p.created_at),
+			MAX(p.created_at)
+		FROM tags
+		LEFT JOIN post_tags ON post_tags.tag_id = tags.id
+		LEFT JOIN posts p ON p.id = post_tags.post_id` + visibleClause("p") + `
+		WHERE tags.name = $1
+		GROUP BY tags.name
+	`
+
+	var tag Tag
+	err := db.QueryRowContext(ctx, query, NormalizeTag(name)).Scan(
+		&tag.Name,
+		&tag.PostCount,
+		&tag.AuthorCount,
+		&tag.PostsLastDay,
+		&tag.PostsLastWeek,
+		&tag.FirstUsedAt,
+		&tag.LastUsedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, http.StatusNotFound, errors.New("notFound")
+	}
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return &tag, http.StatusOK, nil
+}
+
+func (t *Tag) GetTagPosts(name string, page Page, sessionId string) ([]*Post, string, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	viewer := viewerId(ctx, sessionId)
+
+	args := []interface{}{NormalizeTag(name), sql.NullString{String: viewer, Valid: viewer != ""}}
+	after, args, err := page.keyset(args, "p.created_at", "p.id")
+
+	if err != nil {
+		return nil, "", http.StatusBadRequest, err
+	}
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		INNER JOIN post_tags ON post_tags.post_id = p.id
+		INNER JOIN tags ON tags.id = post_tags.tag_id
+		WHERE tags.name = $1` + visibleClause("p") + notLimitedClause("p.author_id", 2) + after + `
+		ORDER BY p.created_at DESC, p.id DESC
+	` + page.limitClause()
+
+	posts, err := queryPosts(ctx, query, args...)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	posts, next := page.nextPostCursor(posts)
+
+	err = hydratePosts(ctx, posts, viewer)
+
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return posts, next, http.StatusOK, nil
+}
@@ -0,0 +1,562 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const trendsRefreshInterval = 5 * time.Minute
+
+// Engagement older than this no longer counts towards trends
+const trendsWindow = 48 * time.Hour
+
+// Engagement loses half of its weight every half life
+const trendsHalfLife = 6 * time.Hour
+
+// Extra weight for each distinct user engaging with a post, so a post many
+// people like outranks one a few people react to over and over
+const trendsUserWeight = 2.0
+
+// Weight for each distinct author using a tag
+const trendsAuthorWeight = 3.0
+
+const trendingPostsKept = 500
+
+const defaultTrendsLimit = 10
+const maxTrendsLimit = 50
+
+const trendsCacheKey = "trends"
+
+type Trend struct{}
+
+type TrendingTag struct {
+	Name         string  `json:"name"`
+	Score        float64 `json:"score"`
+	AccountCount int     `json:"account_count"`
+}
+
+type ExcludedTag struct {
+	Name       string    `json:"name"`
+	ExcludedBy *string   `json:"excluded_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type trendingPost struct {
+	postId   string
+	authorId string
+	score    float64
+}
+
+type trendingTagAuthor struct {
+	name     string
+	authorId string
+	score    float64
+}
+
+// trendSnapshot holds the scores computed by the last refresh, minus excluded
+// tags and limited or suspended accounts. Mutes differ per viewer so they are
+// applied when reading.
+type trendSnapshot struct {
+	posts []trendingPost
+	tags  []trendingTagAuthor
+}
+
+// trendCache keeps the last snapshot loaded. Replicas normally drop it when
+// trends are refreshed, but that invalidation can be missed, for example
+// while a replica's pubsub listener reconnects, so it also expires on its own
+// after one refresh interval.
+type trendCache struct {
+	mu       sync.Mutex
+	snapshot *trendSnapshot
+	loadedAt time.Time
+	// Bumped on every clear, so a load that started before the clear
+	// doesn't store what it read
+	generation int
+	loading    *trendLoad
+}
+
+// trendLoad is a snapshot being loaded, shared by every request that misses
+// the cache while it runs
+type trendLoad struct {
+	done     chan struct{}
+	snapshot *trendSnapshot
+	err      error
+}
+
+var trends = newTrendCache()
+
+func newTrendCache() *trendCache {
+	c := &trendCache{}
+	caches.register(trendsCacheKey, c.clear)
+
+	return c
+}
+
+func (c *trendCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.snapshot = nil
+	c.generation++
+}
+
+// get returns the cached snapshot, loading it when it is missing or stale.
+// The lock isn't held while loading, so other callers only wait for a load
+// already in progress.
+func (c *trendCache) get(ctx context.Context) (*trendSnapshot, error) {
+	c.mu.Lock()
+
+	if c.snapshot != nil && time.Since(c.loadedAt) < trendsRefreshInterval {
+		snapshot := c.snapshot
+		c.mu.Unlock()
+
+		return snapshot, nil
+	}
+
+	if load := c.loading; load != nil {
+		c.mu.Unlock()
+
+		select {
+		case <-load.done:
+			return load.snapshot, load.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	load := &trendLoad{done: make(chan struct{})}
+	c.loading = load
+	generation := c.generation
+	c.mu.Unlock()
+
+	load.snapshot, load.err = loadTrends(ctx)
+
+	c.mu.Lock()
+	if load.err == nil && c.generation == generation {
+		c.snapshot = load.snapshot
+		c.loadedAt = time.Now()
+	}
+	c.loading = nil
+	c.mu.Unlock()
+
+	close(load.done)
+
+	return load.snapshot, load.err
+}
+
+func loadTrends(ctx context.Context) (*trendSnapshot, error) {
+	postQuery := `
+		SELECT tp.post_id, tp.author_id, tp.score
+		FROM trending_posts tp
+		INNER JOIN users ON users.id = tp.author_id
+		WHERE users.state = 'active'
+		ORDER BY tp.score DESC
+	`
+
+	rows, err := db.QueryContext(ctx, postQuery)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	snapshot := &trendSnapshot{}
+
+	for rows.Next() {
+		var post trendingPost
+
+		if err := rows.Scan(&post.postId, &post.authorId, &post.score); err != nil {
+			return nil, err
+		}
+
+		snapshot.posts = append(snapshot.posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tagQuery := `
+		SELECT tags.name, tta.author_id, tta.score
+		FROM trending_tag_authors tta
+		INNER JOIN tags ON tags.id = tta.tag_id
+		INNER JOIN users ON users.id = tta.author_id
+		WHERE users.state = 'active'
+			AND NOT EXISTS (
+				SELECT 1
+				FROM trend_excluded_tags
+				WHERE trend_excluded_tags.name = tags.name
+			)
+	`
+
+	rows, err = db.QueryContext(ctx, tagQuery)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag trendingTagAuthor
+
+		if err := rows.Scan(&tag.name, &tag.authorId, &tag.score); err != nil {
+			return nil, err
+		}
+
+		snapshot.tags = append(snapshot.tags, tag)
+	}
+
+	return snapshot, rows.Err()
+}
+
+// trendEngagement lists recent likes, reactions and replies, leaving out
+// authors engaging with their own posts and limited or suspended accounts.
+// There are no reposts yet, so they can't count towards trends. $1 is the
+// decay rate per second and $2 the start of the window.
+var trendEngagement = `
+	WITH engagement AS (
+		SELECT e.post_id, p.author_id, e.user_id, e.created_at,
+			exp(-$1::float8 * extract(epoch FROM NOW() - e.created_at)::float8) AS decay
+		FROM (
+			SELECT post_id, user_id, created_at FROM post_likes
+			UNION ALL
+			SELECT post_id, user_id, created_at FROM post_reactions
+			UNION ALL
+			SELECT reply.in_reply_to_id, reply.author_id, reply.created_at
+			FROM posts reply
+			WHERE reply.in_reply_to_id IS NOT NULL` + visibleClause("reply") + `
+		) e
+		INNER JOIN posts p ON p.id = e.post_id
+		INNER JOIN users ON users.id = e.user_id
+		WHERE e.created_at > $2` + visibleClause("p") + `
+			AND e.user_id <> p.author_id
+			AND users.state = 'active'
+	)
+`
+
+// refreshTrends recomputes trend scores. Only one replica does so per
+// interval; the others pick up the new scores through cache invalidation.
+func refreshTrends(ctx context.Context) error {
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	claimed, err := claimRun(ctx, tx, "trends", trendsRefreshInterval)
+
+	if err != nil || !claimed {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM trending_posts`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM trending_tag_authors`)
+
+	if err != nil {
+		return err
+	}
+
+	decay := math.Ln2 / trendsHalfLife.Seconds()
+	since := time.Now().Add(-trendsWindow)
+
+	postQuery := trendEngagement + `
+		INSERT INTO trending_posts (post_id, author_id, score)
+		SELECT scores.post_id, scores.author_id, SUM(scores.score)
+		FROM (
+			SELECT post_id, author_id, SUM(decay) AS score
+			FROM engagement
+			GROUP BY post_id, author_id
+			UNION ALL
+			SELECT post_id, author_id, $3 * MAX(decay)
+			FROM engagement
+			GROUP BY post_id, author_id, user_id
+		) scores
+		INNER JOIN users ON users.id = scores.author_id
+		WHERE users.state = 'active'
+		GROUP BY scores.post_id, scores.author_id
+		ORDER BY SUM(scores.score) DESC
+		LIMIT $4
+	`
+
+	_, err = tx.ExecContext(ctx, postQuery, decay, since, trendsUserWeight, trendingPostsKept)
+
+	if err != nil {
+		return err
+	}
+
+	// Each author counts once per tag, weighted by their latest use, on top
+	// of the engagement their tagged posts received
+	tagQuery := trendEngagement + `
+		INSERT INTO trending_tag_authors (tag_id, author_id, score)
+		SELECT scores.tag_id, scores.author_id, SUM(scores.score)
+		FROM (
+			SELECT post_tags.tag_id, p.author_id,
+				$4 * exp(-$1::float8 * extract(epoch FROM NOW() - MAX(p.created_at))::float8) AS score
+			FROM post_tags
+			INNER JOIN posts p ON p.id = post_tags.post_id
+			WHERE p.created_at > $2` + visibleClause("p") + `
+			GROUP BY post_tags.tag_id, p.author_id
+			UNION ALL
+			SELECT post_tags.tag_id, engagement.author_id, SUM(engagement.decay)
+			FROM engagement
+			INNER JOIN post_tags ON post_tags.post_id = engagement.post_id
+			GROUP BY post_tags.tag_id, engagement.author_id
+			UNION ALL
+			SELECT post_tags.tag_id, engagement.author_id, $3 * MAX(engagement.decay)
+			FROM engagement
+			INNER JOIN post_tags ON post_tags.post_id = engagement.post_id
+			GROUP BY post_tags.tag_id, engagement.author_id, engagement.user_id
+		) scores
+		INNER JOIN users ON users.id = scores.author_id
+		WHERE users.state = 'active'
+		GROUP BY scores.tag_id, scores.author_id
+	`
+
+	_, err = tx.ExecContext(ctx, tagQuery, decay, since, trendsUserWeight, trendsAuthorWeight)
+
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return invalidate(ctx, trendsCacheKey)
+}
+
+func trendsLimit(limit int) int {
+	if limit <= 0 {
+		return defaultTrendsLimit
+	}
+
+	if limit > maxTrendsLimit {
+		return maxTrendsLimit
+	}
+
+	return limit
+}
+
+func (t *Trend) GetTrendingTags(limit int, sessionId string) ([]*TrendingTag, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	snapshot, err := trends.get(ctx)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	hidden, err := hiddenAuthors(ctx, viewerId(ctx, sessionId))
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	byName := map[string]*TrendingTag{}
+	tags := []*TrendingTag{}
+
+	for _, contribution := range snapshot.tags {
+		if hidden[contribution.authorId] {
+			continue
+		}
+
+		tag, ok := byName[contribution.name]
+		if !ok {
+			tag = &TrendingTag{Name: contribution.name}
+			byName[contribution.name] = tag
+			tags = append(tags, tag)
+		}
+
+		tag.Score += contribution.score
+		tag.AccountCount++
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Score != tags[j].Score {
+			return tags[i].Score > tags[j].Score
+		}
+
+		return tags[i].Name < tags[j].Name
+	})
+
+	if limit = trendsLimit(limit); len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	return tags, http.StatusOK, nil
+}
+
+func (t *Trend) GetTrendingPosts(limit int, sessionId string) ([]*Post, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	snapshot, err := trends.get(ctx)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	viewer := viewerId(ctx, sessionId)
+	hidden, err := hiddenAuthors(ctx, viewer)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	limit = trendsLimit(limit)
+	rank := map[string]int{}
+	ids := []string{}
+
+	for _, post := range snapshot.posts {
+		if len(ids) == limit {
+			break
+		}
+
+		if hidden[post.authorId] {
+			continue
+		}
+
+		rank[post.postId] = len(ids)
+		ids = append(ids, post.postId)
+	}
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		WHERE p.id = ANY($1::uuid[])` + visibleClause("p") + `
+	`
+
+	posts, err := queryPosts(ctx, query, pq.Array(ids))
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	sort.Slice(posts, func(i, j int) bool {
+		return rank[posts[i].ID] < rank[posts[j].ID]
+	})
+
+	err = hydratePosts(ctx, posts, viewer)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return posts, http.StatusOK, nil
+}
+
+func (t *Trend) GetExcludedTags(sessionId string) ([]*ExcludedTag, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, status, err := requireRole(ctx, sessionId, RoleAdmin)
+
+	if err != nil {
+		return nil, status, err
+	}
+
+	query := `
+		SELECT name, excluded_by, created_at
+		FROM trend_excluded_tags
+		ORDER BY name
+	`
+
+	rows, err := db.QueryContext(ctx, query)
+
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer rows.Close()
+
+	tags := []*ExcludedTag{}
+
+	for rows.Next() {
+		var tag ExcludedTag
+
+		if err := rows.Scan(&tag.Name, &tag.ExcludedBy, &tag.CreatedAt); err != nil {
+			return nil, http.StatusInternalServerError, errors.New("serverError")
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	return tags, http.StatusOK, nil
+}
+
+// ExcludeTag keeps a tag out of trends. The tag does not need to have been
+// used yet.
+func (t *Trend) ExcludeTag(name string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, status, err := requireRole(ctx, sessionId, RoleAdmin)
+
+	if err != nil {
+		return status, err
+	}
+
+	name = NormalizeTag(name)
+
+	if name == "" {
+		return http.StatusBadRequest, errors.New("tagRequired")
+	}
+
+	query := `
+		INSERT INTO trend_excluded_tags (name, excluded_by)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err = db.ExecContext(ctx, query, name, userId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToExcludeTag")
+	}
+
+	invalidate(ctx, trendsCacheKey)
+
+	return http.StatusOK, nil
+}
+
+func (t *Trend) IncludeTag(name string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, status, err := requireRole(ctx, sessionId, RoleAdmin)
+
+	if err != nil {
+		return status, err
+	}
+
+	_, err = db.ExecContext(ctx, `DELETE FROM trend_excluded_tags WHERE name = $1`, NormalizeTag(name))
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToIncludeTag")
+	}
+
+	invalidate(ctx, trendsCacheKey)
+
+	return http.StatusOK, nil
+}
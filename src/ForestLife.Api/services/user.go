@@ -3,11 +3,82 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+
+	"github.com/lib/pq"
 )
 
 type User struct{}
 
+// blockedBetween reports whether userId has blocked, or been blocked by, any
+// of otherIds
+func blockedBetween(ctx context.Context, userId string, otherIds []string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_blocks
+			WHERE (blocker_id = $1 AND blocked_id = ANY($2::uuid[]))
+				OR (blocked_id = $1 AND blocker_id = ANY($2::uuid[]))
+		)
+	`
+
+	var blocked bool
+	err := db.QueryRowContext(ctx, query, userId, pq.Array(otherIds)).Scan(&blocked)
+
+	return blocked, err
+}
+
+// notBlockedClause filters out rows whose authorCol has blocked, or been
+// blocked by, the viewer bound to parameter $viewerParam
+func notBlockedClause(authorCol string, viewerParam int) string {
+	return fmt.Sprintf(`
+		AND NOT EXISTS (
+			SELECT 1
+			FROM user_blocks
+			WHERE (user_blocks.blocker_id = $%[2]d AND user_blocks.blocked_id = %[1]s)
+				OR (user_blocks.blocker_id = %[1]s AND user_blocks.blocked_id = $%[2]d)
+		)`, authorCol, viewerParam)
+}
+
+// hiddenAuthors returns the users whose content viewerId has muted or, due
+// to a block in either direction, cannot see
+func hiddenAuthors(ctx context.Context, viewerId string) (map[string]bool, error) {
+	hidden := map[string]bool{}
+
+	if viewerId == "" {
+		return hidden, nil
+	}
+
+	query := `
+		SELECT muted_id FROM user_mutes WHERE muter_id = $1
+		UNION
+		SELECT blocked_id FROM user_blocks WHERE blocker_id = $1
+		UNION
+		SELECT blocker_id FROM user_blocks WHERE blocked_id = $1
+	`
+
+	rows, err := db.QueryContext(ctx, query, viewerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId string
+
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+
+		hidden[userId] = true
+	}
+
+	return hidden, rows.Err()
+}
+
 func (u *User) Follow(followId string, sessionId string) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -22,6 +93,16 @@ func (u *User) Follow(followId string, sessionId string) (int, error) {
 		return http.StatusBadRequest, errors.New("cantFollowSelf")
 	}
 
+	blocked, err := blockedBetween(ctx, userId, []string{followId})
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToFollow")
+	}
+
+	if blocked {
+		return http.StatusForbidden, errors.New("blocked")
+	}
+
 	query := `
 		INSERT INTO follow_relationships (followee_id, follower_id)
 		VALUES ($1, $2)
@@ -33,6 +114,9 @@ func (u *User) Follow(followId string, sessionId string) (int, error) {
 		return http.StatusInternalServerError, errors.New("unableToFollow")
 	}
 
+	// Notifications are best effort and never fail the follow itself
+	notify(ctx, followId, userId, NotificationFollow, "")
+
 	return http.StatusOK, nil
 }
 
@@ -63,3 +147,135 @@ func (u *User) Unfollow(unfollowId string, sessionId string) (int, error) {
 
 	return http.StatusOK, nil
 }
+
+func (u *User) Block(blockId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	if userId == blockId {
+		return http.StatusBadRequest, errors.New("cantBlockSelf")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("serverError")
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO user_blocks (blocker_id, blocked_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err = tx.ExecContext(ctx, query, userId, blockId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToBlock")
+	}
+
+	// Blocking someone also ends any follow between the two users
+	unfollowQuery := `
+		DELETE FROM follow_relationships
+		WHERE (followee_id = $1 AND follower_id = $2)
+			OR (followee_id = $2 AND follower_id = $1)
+	`
+
+	_, err = tx.ExecContext(ctx, unfollowQuery, userId, blockId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToBlock")
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToBlock")
+	}
+
+	return http.StatusOK, nil
+}
+
+func (u *User) Unblock(unblockId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		DELETE FROM user_blocks
+		WHERE blocker_id = $1 AND blocked_id = $2
+	`
+
+	_, err = db.ExecContext(ctx, query, userId, unblockId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToUnblock")
+	}
+
+	return http.StatusOK, nil
+}
+
+func (u *User) Mute(muteId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	if userId == muteId {
+		return http.StatusBadRequest, errors.New("cantMuteSelf")
+	}
+
+	query := `
+		INSERT INTO user_mutes (muter_id, muted_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err = db.ExecContext(ctx, query, userId, muteId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToMute")
+	}
+
+	return http.StatusOK, nil
+}
+
+func (u *User) Unmute(unmuteId string, sessionId string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	userId, err := auth.GetUserId(ctx, sessionId)
+
+	if err != nil {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	query := `
+		DELETE FROM user_mutes
+		WHERE muter_id = $1 AND muted_id = $2
+	`
+
+	_, err = db.ExecContext(ctx, query, userId, unmuteId)
+
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("unableToUnmute")
+	}
+
+	return http.StatusOK, nil
+}
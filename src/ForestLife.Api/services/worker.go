@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// StartWorkers runs the periodic background jobs until ctx is cancelled.
+// Every replica runs them; jobs that must only run once at a time take a
+// database lock themselves. Errors are passed to onError.
+func StartWorkers(ctx context.Context, onError func(error)) {
+	go runEvery(ctx, trendsRefreshInterval, refreshTrends, onError)
+	go runEvery(ctx, scheduledPostsInterval, publishScheduledPosts, onError)
+	go runEvery(ctx, purgeInterval, purgeDeletedPosts, onError)
+	go runEvery(ctx, pollEndedInterval, notifyEndedPolls, onError)
+	go runEvery(ctx, linkPreviewInterval, fetchLinkPreviews, onError)
+	go runEvery(ctx, accountStateInterval, expireAccountStates, onError)
+}
+
+// runEvery calls job immediately and then once per interval
+func runEvery(ctx context.Context, interval time.Duration, job func(context.Context) error, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		jobCtx, cancel := context.WithTimeout(ctx, dbTimeout)
+		err := job(jobCtx)
+		cancel()
+
+		if err != nil && ctx.Err() == nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimRun records that the job called name is running, unless another
+// replica already ran it within interval. The claim is held until tx ends,
+// so replicas starting the job at the same time run it only once.
+func claimRun(ctx context.Context, tx *sql.Tx, name string, interval time.Duration) (bool, error) {
+	query := `
+		INSERT INTO worker_runs (name, ran_at)
+		VALUES ($1, NOW())
+		ON CONFLICT (name) DO UPDATE
+		SET ran_at = NOW()
+		WHERE worker_runs.ran_at < NOW() - $2 * INTERVAL '1 second'
+		RETURNING name
+	`
+
+	// Leave some slack so replicas ticking slightly apart don't skip a run
+	interval -= interval / 10
+
+	var claimed string
+	err := tx.QueryRowContext(ctx, query, name, interval.Seconds()).Scan(&claimed)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return err == nil, err
+}
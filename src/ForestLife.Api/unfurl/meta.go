@@ -0,0 +1,130 @@
+package unfurl
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const maxTitleLength = 200
+const maxDescriptionLength = 500
+const maxSiteNameLength = 100
+const maxImageURLLength = 2048
+
+// Pages in the wild are rarely well formed, so rather than parsing the whole
+// document the tags that matter are picked out one by one
+var tagPattern = regexp.MustCompile(`(?is)<(meta|/head|body)\b([^>]*)>|<title\b[^>]*>(.*?)</title\s*>`)
+
+var attrPattern = regexp.MustCompile(`(?s)([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+var charsetPattern = regexp.MustCompile(`(?i)<meta\b[^>]*charset\s*=\s*["']?\s*([a-zA-Z0-9_.:-]+)`)
+
+func attributes(tag string) map[string]string {
+	attrs := map[string]string{}
+
+	for _, match := range attrPattern.FindAllStringSubmatch(tag, -1) {
+		name := strings.ToLower(match[1])
+
+		if _, ok := attrs[name]; ok {
+			continue
+		}
+
+		attrs[name] = html.UnescapeString(match[2] + match[3] + match[4])
+	}
+
+	return attrs
+}
+
+// parse reads the preview metadata from page, preferring OpenGraph, then
+// Twitter cards, then plain HTML. base is the address the page came from.
+func parse(page string, base *url.URL) *Preview {
+	found := map[string]string{}
+	set := func(key string, value string) {
+		if _, ok := found[key]; !ok && strings.TrimSpace(value) != "" {
+			found[key] = value
+		}
+	}
+
+	for _, match := range tagPattern.FindAllStringSubmatch(page, -1) {
+		tag := strings.ToLower(match[1])
+
+		if tag == "/head" || tag == "body" {
+			break
+		}
+
+		if tag == "" {
+			set("title", html.UnescapeString(match[3]))
+			continue
+		}
+
+		attrs := attributes(match[2])
+
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+
+		set("meta:"+strings.ToLower(key), attrs["content"])
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := found[key]; ok {
+				return value
+			}
+		}
+
+		return ""
+	}
+
+	preview := &Preview{
+		URL:         base.String(),
+		Title:       trimText(first("meta:og:title", "meta:twitter:title", "title"), maxTitleLength),
+		Description: trimText(first("meta:og:description", "meta:twitter:description", "meta:description"), maxDescriptionLength),
+		SiteName:    trimText(first("meta:og:site_name", "meta:application-name"), maxSiteNameLength),
+	}
+
+	if image := resolve(base, first("meta:og:image:secure_url", "meta:og:image", "meta:og:image:url", "meta:twitter:image", "meta:twitter:image:src")); len(image) <= maxImageURLLength {
+		preview.Image = image
+	}
+
+	if preview.SiteName == "" {
+		preview.SiteName = strings.TrimPrefix(base.Hostname(), "www.")
+	}
+
+	return preview
+}
+
+// resolve turns ref into an absolute http or https URL relative to base, or
+// returns an empty string when it can't
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+
+	if ref == "" {
+		return ""
+	}
+
+	parsed, err := base.Parse(ref)
+
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return ""
+	}
+
+	return parsed.String()
+}
+
+func metaCharset(body []byte) string {
+	// The charset has to be declared within the first 1024 bytes
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+
+	match := charsetPattern.FindSubmatch(body)
+
+	if match == nil {
+		return ""
+	}
+
+	return string(match[1])
+}
@@ -0,0 +1,276 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const defaultTimeout = 5 * time.Second
+const defaultMaxRedirects = 3
+
+// Metadata lives in the head, so there's no need to read whole pages
+const defaultMaxBytes = 512 * 1024
+
+const userAgent = "ForestLifeBot/1.0 (+link previews)"
+
+var ErrBlockedAddress = errors.New("unfurl: address is not publicly routable")
+var ErrTooManyRedirects = errors.New("unfurl: too many redirects")
+var ErrUnsupportedScheme = errors.New("unfurl: only http and https links are fetched")
+var ErrNotHTML = errors.New("unfurl: response is not an HTML page")
+
+// Preview is the card metadata found on a page
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+}
+
+type Options struct {
+	// Timeout bounds the whole fetch, redirects and body included
+	Timeout      time.Duration
+	MaxRedirects int
+	MaxBytes     int64
+
+	// AllowPrivate lets the fetcher reach loopback and private addresses.
+	// It exists for tests running against a local server and must never be
+	// set in production.
+	AllowPrivate bool
+
+	// checkAddress replaces checkPublic, so tests can let one local server
+	// through and still see every other address checked
+	checkAddress func(address string) error
+}
+
+// Fetcher fetches pages for link previews. Connections are only made to
+// public addresses: the check runs on the resolved address at connect time,
+// for every redirect, so DNS answers can't point it at the internal network.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+func New(opts Options) *Fetcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = defaultMaxRedirects
+	}
+
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultMaxBytes
+	}
+
+	check := opts.checkAddress
+	if check == nil {
+		check = checkPublic
+	}
+
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if opts.AllowPrivate {
+				return nil
+			}
+
+			return check(address)
+		},
+	}
+
+	transport := &http.Transport{
+		// Proxies from the environment would bypass the address check
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrUnsupportedScheme
+			}
+
+			return nil
+		},
+	}
+
+	return &Fetcher{client: client, maxBytes: opts.MaxBytes}
+}
+
+// Fetch downloads rawURL and reads its OpenGraph and Twitter card metadata,
+// falling back to the page's title and description
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	target, err := url.Parse(rawURL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, ErrUnsupportedScheme
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unfurl: unexpected status %d", resp.StatusCode)
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// resp.Request is the last request made, so relative links resolve
+	// against the page the redirects ended on
+	return parse(decode(body, params["charset"]), resp.Request.URL), nil
+}
+
+// decode converts body to UTF-8 using the charset from the response headers
+// or, failing that, a <meta charset> near the top of the page
+func decode(body []byte, charset string) string {
+	if charset == "" {
+		charset = metaCharset(body)
+	}
+
+	if charset == "" {
+		return string(body)
+	}
+
+	encoding, err := htmlindex.Get(charset)
+
+	if err != nil {
+		return string(body)
+	}
+
+	decoded, err := encoding.NewDecoder().Bytes(body)
+
+	if err != nil {
+		return string(body)
+	}
+
+	return string(decoded)
+}
+
+// Ranges not covered by the net.IP helpers that still aren't reachable on
+// the public internet
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"2001:db8::/32",
+)
+
+// checkPublic refuses to connect to address unless it is publicly routable
+func checkPublic(address string) error {
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+		return ErrBlockedAddress
+	}
+
+	return nil
+}
+
+func isPublic(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, blocked := range blockedNets {
+		if blocked.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			panic(err)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// trimText collapses whitespace and cuts s to at most max runes
+func trimText(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+
+	runes := []rune(s)
+	if len(runes) > max {
+		return strings.TrimSpace(string(runes[:max-1])) + "…"
+	}
+
+	return s
+}
@@ -0,0 +1,259 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveHTML(page string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}))
+}
+
+func TestFetchMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want Preview
+	}{
+		{
+			name: "OpenGraph",
+			page: `<html><head>
+				<title>Page title</title>
+				<meta property="og:title" content="Forest &amp; Trees">
+				<meta property="og:description" content="A walk   in the woods">
+				<meta property="og:image" content="/images/oak.png">
+				<meta property="og:site_name" content="Forest Life">
+				<meta name="twitter:title" content="Twitter title">
+			</head><body></body></html>`,
+			want: Preview{
+				Title:       "Forest & Trees",
+				Description: "A walk in the woods",
+				Image:       "/images/oak.png",
+				SiteName:    "Forest Life",
+			},
+		},
+		{
+			name: "Twitter card",
+			page: `<html><head>
+				<title>Page title</title>
+				<meta name="twitter:title" content='Twitter title'>
+				<meta name="twitter:description" content="Twitter description">
+				<meta name="twitter:image" content="https://cdn.example.com/fern.jpg">
+			</head></html>`,
+			want: Preview{
+				Title:       "Twitter title",
+				Description: "Twitter description",
+				Image:       "https://cdn.example.com/fern.jpg",
+			},
+		},
+		{
+			name: "plain HTML",
+			page: `<html><head>
+				<title>Just a title</title>
+				<meta name="description" content="Just a description">
+			</head></html>`,
+			want: Preview{
+				Title:       "Just a title",
+				Description: "Just a description",
+			},
+		},
+		{
+			name: "tags after the head are ignored",
+			page: `<html><head><title>Head</title></head>
+				<body><meta property="og:title" content="Body"></body></html>`,
+			want: Preview{
+				Title: "Head",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := serveHTML(test.page)
+			defer server.Close()
+
+			preview, err := New(Options{AllowPrivate: true}).Fetch(context.Background(), server.URL)
+
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+
+			want := test.want
+			want.URL = server.URL
+
+			if strings.HasPrefix(want.Image, "/") {
+				want.Image = server.URL + want.Image
+			}
+
+			if want.SiteName == "" {
+				want.SiteName = "127.0.0.1"
+			}
+
+			if *preview != want {
+				t.Errorf("got %+v, want %+v", *preview, want)
+			}
+		})
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hops int
+		fmt.Sscanf(r.URL.Path, "/hops/%d", &hops)
+
+		if hops > 0 {
+			http.Redirect(w, r, fmt.Sprintf("%s/hops/%d", server.URL, hops-1), http.StatusFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Arrived</title>`)
+	}))
+	defer server.Close()
+
+	fetcher := New(Options{AllowPrivate: true, MaxRedirects: 2})
+
+	preview, err := fetcher.Fetch(context.Background(), server.URL+"/hops/2")
+
+	if err != nil {
+		t.Fatalf("Fetch within the redirect cap: %v", err)
+	}
+
+	if preview.Title != "Arrived" || preview.URL != server.URL+"/hops/0" {
+		t.Errorf("got %+v, want the page the redirects ended on", *preview)
+	}
+
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/hops/3")
+
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("got %v, want ErrTooManyRedirects", err)
+	}
+}
+
+func TestFetchBodyLimit(t *testing.T) {
+	padding := strings.Repeat("<!-- filler -->", 100)
+	server := serveHTML(`<html><head><title>Early</title>` + padding + `<meta property="og:title" content="Late"></head></html>`)
+	defer server.Close()
+
+	preview, err := New(Options{AllowPrivate: true, MaxBytes: 512}).Fetch(context.Background(), server.URL)
+
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if preview.Title != "Early" {
+		t.Errorf("got title %q, want metadata past the limit to be ignored", preview.Title)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	started := time.Now()
+	_, err := New(Options{AllowPrivate: true, Timeout: 100 * time.Millisecond}).Fetch(context.Background(), server.URL)
+
+	if err == nil {
+		t.Fatal("Fetch succeeded, want a timeout")
+	}
+
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %v, want it cut off by the timeout", elapsed)
+	}
+}
+
+func TestFetchRejects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	fetcher := New(Options{AllowPrivate: true})
+
+	if _, err := fetcher.Fetch(context.Background(), server.URL); !errors.Is(err, ErrNotHTML) {
+		t.Errorf("got %v, want ErrNotHTML", err)
+	}
+
+	if _, err := fetcher.Fetch(context.Background(), "ftp://example.com/file"); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("got %v, want ErrUnsupportedScheme", err)
+	}
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	private := serveHTML(`<title>Internal</title>`)
+	defer private.Close()
+
+	_, err := New(Options{}).Fetch(context.Background(), private.URL)
+
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("got %v, want ErrBlockedAddress", err)
+	}
+
+	// The redirecting server stands in for a public site. Only its address
+	// is let through; the private server is checked like any other.
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, private.URL, http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	fetcher := New(Options{
+		checkAddress: func(address string) error {
+			if address == redirector.Listener.Addr().String() {
+				return nil
+			}
+
+			return checkPublic(address)
+		},
+	})
+
+	_, err = fetcher.Fetch(context.Background(), redirector.URL)
+
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("got %v, want a redirect to a private address to be blocked", err)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+	}
+
+	for _, test := range tests {
+		if got := isPublic(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("isPublic(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}